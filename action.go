@@ -0,0 +1,115 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// actions for source objects after routing
+const (
+	SourceActionNone    = "none"
+	SourceActionDelete  = "delete"
+	SourceActionArchive = "archive"
+	SourceActionTag     = "tag"
+)
+
+// RoutedTagKey and RoutedAtTagKey are tag keys set to source objects by the tag action.
+var (
+	RoutedTagKey   = "routed"
+	RoutedAtTagKey = "routed_at"
+)
+
+// keepSource reports whether the source action must be skipped to keep the source object,
+// because no records are routed from it or some records failed to be routed.
+func (r *Router) keepSource(s3url string, outputs, recordErrors int) bool {
+	if r.option.SourceAction == SourceActionNone {
+		return false
+	}
+	switch {
+	case outputs == 0:
+		log.Printf("[warn] source action %s is skipped for %s. no records are routed", r.option.SourceAction, s3url)
+		return true
+	case recordErrors > 0:
+		log.Printf("[warn] source action %s is skipped for %s. %d records failed to be routed", r.option.SourceAction, s3url, recordErrors)
+		return true
+	}
+	return false
+}
+
+// doSourceAction does the source action for the source object after all destinations are written.
+func (r *Router) doSourceAction(ctx context.Context, s3url string) error {
+	if r.option.SourceAction == SourceActionNone {
+		return nil
+	}
+	storage, scheme, bucket, key, err := r.sourceStorage(s3url)
+	if err != nil {
+		return err
+	}
+	switch r.option.SourceAction {
+	case SourceActionDelete:
+		return r.deleteSource(ctx, storage, s3url, bucket, key)
+	case SourceActionArchive:
+		archiveBucket := bucket
+		if r.option.archiveBucket != "" {
+			archiveBucket = r.option.archiveBucket
+		}
+		archiveKey := path.Join(r.option.archivePrefix, key)
+		log.Println("[info] archive source", s3url, "to", objectURL(scheme, archiveBucket, archiveKey))
+		_, err := storage.Copy(ctx, &s3.CopyObjectInput{
+			Bucket:     aws.String(archiveBucket),
+			Key:        aws.String(archiveKey),
+			CopySource: aws.String(copySource(bucket, key)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive source %s: %w", s3url, err)
+		}
+		return r.deleteSource(ctx, storage, s3url, bucket, key)
+	case SourceActionTag:
+		log.Println("[info] tag source", s3url)
+		// PutObjectTagging replaces all tags, so merge with existing tags
+		out, err := storage.GetTagging(ctx, &s3.GetObjectTaggingInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get tags of source %s: %w", s3url, err)
+		}
+		tags := []types.Tag{
+			{Key: aws.String(RoutedTagKey), Value: aws.String("true")},
+			{Key: aws.String(RoutedAtTagKey), Value: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		}
+		for _, tag := range out.TagSet {
+			if k := aws.ToString(tag.Key); k != RoutedTagKey && k != RoutedAtTagKey {
+				tags = append(tags, tag)
+			}
+		}
+		_, err = storage.PutTagging(ctx, &s3.PutObjectTaggingInput{
+			Bucket:  aws.String(bucket),
+			Key:     aws.String(key),
+			Tagging: &types.Tagging{TagSet: tags},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to tag source %s: %w", s3url, err)
+		}
+	}
+	return nil
+}
+
+func (r *Router) deleteSource(ctx context.Context, storage Storage, s3url, bucket, key string) error {
+	log.Println("[info] delete source", s3url)
+	_, err := storage.Delete(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete source %s: %w", s3url, err)
+	}
+	return nil
+}
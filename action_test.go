@@ -0,0 +1,108 @@
+package router_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestSourceAction(t *testing.T) {
+	ctx := context.Background()
+	for _, action := range []string{router.SourceActionDelete, router.SourceActionArchive, router.SourceActionTag} {
+		storage := router.NewMemoryStorage()
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket:  aws.String("src"),
+			Key:     aws.String("logs/x.log"),
+			Body:    strings.NewReader(`{"tag":"a"}` + "\n"),
+			Tagging: aws.String("owner=me"),
+		})
+		opt := testRouterOption("dest")
+		opt.KeyPrefix = "{{ .tag }}"
+		opt.SourceAction = action
+		opt.ArchiveTo = "s3://archive/raw/"
+		r, err := router.New(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetSourceStorage("s3", storage)
+		r.SetDestinationStorage(storage)
+		if err := r.Run(ctx, "s3://src/logs/x.log"); err != nil {
+			t.Fatal(action, err)
+		}
+		src := storage.Object("src", "logs/x.log")
+		switch action {
+		case router.SourceActionDelete:
+			if src != nil {
+				t.Errorf("%s: source must be deleted", action)
+			}
+		case router.SourceActionArchive:
+			if src != nil {
+				t.Errorf("%s: source must be deleted", action)
+			}
+			if storage.Object("archive", "raw/logs/x.log") == nil {
+				t.Errorf("%s: source must be archived", action)
+			}
+		case router.SourceActionTag:
+			tags := map[string]string{}
+			for _, tag := range src.Tags {
+				tags[*tag.Key] = *tag.Value
+			}
+			if tags["owner"] != "me" || tags["routed"] != "true" || tags["routed_at"] == "" {
+				t.Errorf("%s: unexpected tags %v", action, tags)
+			}
+		}
+	}
+}
+
+func TestSourceActionKeepsSource(t *testing.T) {
+	ctx := context.Background()
+	for name, body := range map[string]string{
+		"all records are invalid": "invalid\n{\n",
+		"a record is invalid":     `{"tag":"a"}` + "\ninvalid\n",
+		"no records":              "",
+		"invalid time":            `{"tag":"a","datetime":"yesterday"}` + "\n",
+		"key prefix fails":        `{"tag":"a","datetime":1}` + "\n",
+	} {
+		storage := router.NewMemoryStorage()
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String("logs/x.log"),
+			Body:   strings.NewReader(body),
+		})
+		opt := testRouterOption("dest")
+		opt.SourceAction = router.SourceActionDelete
+		r, err := router.New(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetSourceStorage("s3", storage)
+		r.SetDestinationStorage(storage)
+		if err := r.Run(ctx, "s3://src/logs/x.log"); err != nil {
+			t.Fatal(name, err)
+		}
+		if storage.Object("src", "logs/x.log") == nil {
+			t.Errorf("%s: source must not be deleted", name)
+		}
+
+		// aggregated sources too
+		agg := r.NewAggregator(router.AggregateOption{})
+		var acked bool
+		if err := agg.Add(ctx, "s3://src/logs/x.log", func() { acked = true }); err != nil {
+			t.Fatal(name, err)
+		}
+		if err := agg.Flush(ctx); err != nil {
+			t.Fatal(name, err)
+		}
+		if !acked {
+			t.Errorf("%s: source must be acked", name)
+		}
+		if storage.Object("src", "logs/x.log") == nil {
+			t.Errorf("%s: aggregated source must not be deleted", name)
+		}
+	}
+}
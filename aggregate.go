@@ -0,0 +1,303 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aggregateKeyBase is a placeholder of key names of aggregated objects, replaced by a unique name on flush.
+const aggregateKeyBase = "aggregate"
+
+// AggregatedMetaValue is the value of the loop guard metadata of aggregated objects.
+var AggregatedMetaValue = "aggregated"
+
+// AggregateOption represents flush conditions of Aggregator.
+// A destination is flushed when any of the conditions is met. Zero means no limit.
+type AggregateOption struct {
+	FlushBytes    int           // size of the (compressed) body
+	FlushRecords  int           // number of records
+	FlushInterval time.Duration // age from the first record
+}
+
+// Aggregator routes records of many source objects into shared destination objects,
+// and flushes them by size, number of records or age.
+type Aggregator struct {
+	r   *Router
+	opt AggregateOption
+
+	mu         sync.Mutex
+	aggregates map[destination]*aggregate
+	sources    map[string]*aggregateSource
+}
+
+type aggregate struct {
+	out     *output
+	created time.Time
+	sources map[string]int // number of Adds of each source
+}
+
+type aggregateSource struct {
+	url          string
+	pending      int // number of unflushed aggregates which have records of the source
+	outputs      int // number of aggregates which had records of the source
+	recordErrors int // number of records which failed to be routed
+	acks         []func()
+}
+
+// NewAggregator creates an Aggregator.
+func (r *Router) NewAggregator(opt AggregateOption) *Aggregator {
+	return &Aggregator{
+		r:          r,
+		opt:        opt,
+		aggregates: make(map[destination]*aggregate),
+		sources:    make(map[string]*aggregateSource),
+	}
+}
+
+// Add routes records of the source object into aggregated destinations.
+// ack is called after all destinations which have records of the source are flushed,
+// and the source action is done.
+func (a *Aggregator) Add(ctx context.Context, s3url string, ack func()) error {
+	log.Println("[info] aggregate", s3url)
+	if _, err := a.r.loopGuard(ctx, s3url); err != nil {
+		return err
+	}
+	src, err := a.r.getObject(ctx, s3url)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	entries, err := decompress(src, a.r.option.SourceCompression)
+	if err != nil {
+		return err
+	}
+
+	// records are routed without the lock, and merged into aggregates under the lock
+	routed := make(map[destination]*output)
+	var recordErrors int
+	for _, entry := range entries {
+		n, err := a.r.routeEntry(entry, aggregateKeyBase, routed, routeMode{collect: true})
+		recordErrors += n
+		if err != nil {
+			// no records of the source are aggregated
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	s := a.sources[s3url]
+	if s == nil {
+		// the same source may be added again while it is pending
+		s = &aggregateSource{url: s3url}
+	}
+	n, err := a.merge(s, routed)
+	s.recordErrors += recordErrors + n
+	if err != nil {
+		// records merged before the error are kept, and will be duplicated by redelivery of the source.
+		// the source is never acked.
+		a.forget(s3url)
+		a.mu.Unlock()
+		return err
+	}
+	s.acks = append(s.acks, ack)
+	// s may be flushed and done by another goroutine after unlock
+	finished := s.pending == 0
+	if !finished {
+		a.sources[s3url] = s
+	}
+	a.mu.Unlock()
+
+	if finished {
+		// no records to wait
+		a.done(ctx, s)
+		return nil
+	}
+	return a.flush(ctx, false)
+}
+
+// merge merges routed records of the source into aggregates, and returns the number of records
+// which failed to be encoded. a.mu must be locked.
+func (a *Aggregator) merge(s *aggregateSource, routed map[destination]*output) (int, error) {
+	outs := make(map[destination]*output, len(a.aggregates))
+	for d, agg := range a.aggregates {
+		outs[d] = agg.out
+	}
+	var recordErrors int
+	added := make(map[destination]bool)
+	for base, src := range routed {
+		for _, rec := range src.collected {
+			d := base
+			if !a.r.option.SortByTime {
+				// sorted records are split into parts on put
+				d = a.r.partDestination(base, outs)
+			}
+			out := outs[d]
+			if out == nil {
+				out = a.r.newOutput(src.attrs)
+				outs[d] = out
+				a.aggregates[d] = &aggregate{out: out, created: time.Now(), sources: make(map[string]int)}
+			}
+			if out.sorter != nil {
+				if err := out.sorter.add(rec); err != nil {
+					return recordErrors, err
+				}
+			} else if err := out.enc.Encode(rec); err != nil {
+				log.Println("[warn] failed to encode record", err)
+				recordErrors++
+				continue
+			}
+			out.count(rec, a.r.option.TimeKey)
+			if !added[d] {
+				added[d] = true
+				a.aggregates[d].sources[s.url]++
+				s.pending++
+				s.outputs++
+			}
+		}
+	}
+	return recordErrors, nil
+}
+
+// Flush flushes all destinations regardless of the flush conditions.
+func (a *Aggregator) Flush(ctx context.Context) error {
+	return a.flush(ctx, true)
+}
+
+// FlushExpired flushes destinations which meet the flush conditions.
+func (a *Aggregator) FlushExpired(ctx context.Context) error {
+	return a.flush(ctx, false)
+}
+
+func (a *Aggregator) flush(ctx context.Context, all bool) error {
+	a.mu.Lock()
+	flushing := make(map[destination]*aggregate)
+	for d, agg := range a.aggregates {
+		if all || a.expired(agg) {
+			flushing[d] = agg
+			delete(a.aggregates, d)
+		}
+	}
+	a.mu.Unlock()
+
+	var errs []error
+	for d, agg := range flushing {
+		recordErrors, err := a.put(ctx, d, agg)
+		if err != nil {
+			errs = append(errs, err)
+			// sources of the failed destination are never acked, and will be redelivered
+			a.mu.Lock()
+			for u := range agg.sources {
+				a.forget(u)
+			}
+			a.mu.Unlock()
+			continue
+		}
+		a.mu.Lock()
+		var acks []*aggregateSource
+		for u, n := range agg.sources {
+			s := a.sources[u]
+			if s == nil {
+				continue
+			}
+			s.pending -= n
+			// records which failed to be encoded can not be attributed to a source
+			s.recordErrors += recordErrors
+			if s.pending == 0 {
+				delete(a.sources, u)
+				acks = append(acks, s)
+			}
+		}
+		a.mu.Unlock()
+		for _, s := range acks {
+			a.done(ctx, s)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// forget forgets the source not to be acked. a.mu must be locked.
+func (a *Aggregator) forget(s3url string) {
+	delete(a.sources, s3url)
+	for _, agg := range a.aggregates {
+		delete(agg.sources, s3url)
+	}
+}
+
+func (a *Aggregator) expired(agg *aggregate) bool {
+	switch {
+	case a.opt.FlushRecords > 0 && agg.out.records >= a.opt.FlushRecords:
+		return true
+	case a.opt.FlushBytes > 0 && agg.out.size() >= a.opt.FlushBytes:
+		return true
+	case a.opt.FlushInterval > 0 && time.Since(agg.created) >= a.opt.FlushInterval:
+		return true
+	}
+	return false
+}
+
+// put puts the aggregated destination with a unique key name.
+// Sorted records are split into parts by the object limits on put.
+// Sources of a failed destination are never acked, so they will be redelivered.
+// It returns the number of records which failed to be encoded.
+func (a *Aggregator) put(ctx context.Context, d destination, agg *aggregate) (int, error) {
+	outs, recordErrors, err := a.r.sortOutputs(map[destination]*output{d: agg.out})
+	if err != nil {
+		return recordErrors, err
+	}
+	keyBase := uniqueKeyBase()
+	var errs []error
+	for d, out := range outs {
+		buf := out.enc.Buffer()
+		if c, isCloser := buf.(io.Closer); isCloser {
+			c.Close()
+		}
+		d.Key = path.Join(path.Dir(d.Key), keyBase+strings.TrimPrefix(path.Base(d.Key), aggregateKeyBase))
+		body := bytes.NewReader(buf.Bytes())
+		log.Printf("[info] flush %s %d bytes %d records from %d sources", d.String(), body.Len(), out.records, len(agg.sources))
+		if !a.r.option.PutS3 {
+			continue
+		}
+		meta := map[string]string{
+			a.r.option.MetaHeaderName: AggregatedMetaValue,
+		}
+		res := &OutputResult{Destination: d.String()}
+		a.r.putObjectWithRetry(ctx, d, body, meta, out.attrs, res)
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", res.Destination, res.Err))
+		}
+	}
+	return recordErrors, errors.Join(errs...)
+}
+
+// done does the source action and acks the source whose records are all flushed.
+// The source action is skipped when no records of the source are routed or some records failed.
+func (a *Aggregator) done(ctx context.Context, s *aggregateSource) {
+	if a.r.option.PutS3 && !a.r.keepSource(s.url, s.outputs, s.recordErrors) {
+		if err := a.r.doSourceAction(ctx, s.url); err != nil {
+			log.Println("[error]", err)
+			return
+		}
+	}
+	for _, ack := range s.acks {
+		if ack != nil {
+			ack()
+		}
+	}
+}
+
+// uniqueKeyBase returns a key name which is sortable by time.
+func uniqueKeyBase() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,216 @@
+package router_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func newAggregateTestRouter(t *testing.T, storage *router.MemoryStorage) *router.Router {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String(fmt.Sprintf("logs/%d.log", i)),
+			Body:   strings.NewReader(fmt.Sprintf(`{"tag":"app","n":%d}`+"\n"+`{"tag":"db","n":%d}`+"\n", i, i)),
+		})
+	}
+	opt := testRouterOption("dest")
+	opt.KeyPrefix = "{{ .tag }}"
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	return r
+}
+
+func TestAggregator(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	r := newAggregateTestRouter(t, storage)
+	agg := r.NewAggregator(router.AggregateOption{FlushRecords: 3})
+
+	acked := map[string]int{}
+	for i := 0; i < 3; i++ {
+		src := fmt.Sprintf("s3://src/logs/%d.log", i)
+		if err := agg.Add(ctx, src, func() { acked[src]++ }); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && len(acked) > 0 {
+			t.Errorf("sources must not be acked before flush: %v", acked)
+		}
+	}
+	// the same source is added again before flush
+	if err := agg.Add(ctx, "s3://src/logs/0.log", func() { acked["s3://src/logs/0.log"]++ }); err != nil {
+		t.Fatal(err)
+	}
+	if err := agg.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		src := fmt.Sprintf("s3://src/logs/%d.log", i)
+		expected := 1
+		if i == 0 {
+			expected = 2
+		}
+		if acked[src] != expected {
+			t.Errorf("%s must be acked %d times: %v", src, expected, acked)
+		}
+	}
+
+	for _, tag := range []string{"app", "db"} {
+		list, _ := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest"), Prefix: aws.String(tag + "/")})
+		var records int
+		for _, obj := range list.Contents {
+			o := storage.Object("dest", *obj.Key)
+			records += strings.Count(string(o.Body), "\n")
+			if o.Metadata[router.MetaHeaderName] != router.AggregatedMetaValue {
+				t.Errorf("unexpected metadata %v", o.Metadata)
+			}
+		}
+		// 3 records are flushed by FlushRecords, and 1 record by Flush
+		if len(list.Contents) != 2 || records != 4 {
+			t.Errorf("%s: unexpected %d objects with %d records", tag, len(list.Contents), records)
+		}
+	}
+}
+
+func TestServeMessage(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	r := newAggregateTestRouter(t, storage)
+	agg := r.NewAggregator(router.AggregateOption{})
+
+	var deleted int
+	body := `{"Records":[{"s3":{"bucket":{"name":"src"},"object":{"key":"logs/0.log"}}},{"s3":{"bucket":{"name":"src"},"object":{"key":"logs/1.log"}}}]}`
+	if err := router.DoTestServeMessage(ctx, r, agg, body, func() { deleted++ }); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 0 {
+		t.Error("message must not be deleted before flush")
+	}
+	if err := agg.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Errorf("message must be deleted once after flush: %d", deleted)
+	}
+
+	if err := router.DoTestServeMessage(ctx, r, agg, `{"Event":"s3:TestEvent"}`, func() { deleted++ }); err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Error("test event must be deleted")
+	}
+}
+
+func TestAggregatorAddFailure(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	r := newAggregateTestRouter(t, storage)
+	// a line longer than the buffer of the scanner fails routing after a record
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("src"),
+		Key:    aws.String("logs/long.log"),
+		Body:   strings.NewReader(`{"tag":"app"}` + "\n" + strings.Repeat("x", 1024*1024) + "\n"),
+	})
+	agg := r.NewAggregator(router.AggregateOption{})
+	var acked bool
+	if err := agg.Add(ctx, "s3://src/logs/long.log", func() { acked = true }); err == nil {
+		t.Fatal("add must fail")
+	}
+	if err := agg.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if acked {
+		t.Error("failed source must not be acked")
+	}
+	list, _ := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest")})
+	if len(list.Contents) != 0 {
+		t.Errorf("records of the failed source must not be aggregated: %d objects", len(list.Contents))
+	}
+}
+
+func TestCheckVisibilityTimeout(t *testing.T) {
+	cases := []struct {
+		visibilityTimeout string
+		flushInterval     time.Duration
+		ok                bool
+	}{
+		{"600", 5 * time.Minute, true},
+		{"300", 5 * time.Minute, false},
+		{"30", 0, false},
+		{"", 5 * time.Minute, false},
+	}
+	for _, c := range cases {
+		opt := &router.ServeOption{AggregateOption: router.AggregateOption{FlushInterval: c.flushInterval}}
+		err := router.DoTestCheckVisibilityTimeout(c.visibilityTimeout, opt)
+		if c.ok && err != nil {
+			t.Errorf("%s %s: unexpected error %s", c.visibilityTimeout, c.flushInterval, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("%s %s: must fail", c.visibilityTimeout, c.flushInterval)
+		}
+	}
+}
+
+func TestAggregatorConcurrentFlush(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	r := newAggregateTestRouter(t, storage)
+	const n = 5000
+	for i := 0; i < n; i++ {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String(fmt.Sprintf("many/%d.log", i)),
+			Body:   strings.NewReader(`{"tag":"app"}` + "\n"),
+		})
+	}
+	// every aggregate expires immediately, and is flushed by Add or the flusher
+	agg := r.NewAggregator(router.AggregateOption{FlushInterval: time.Nanosecond})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				agg.FlushExpired(ctx)
+			}
+		}
+	}()
+	var mu sync.Mutex
+	acked := make(map[int]int, n)
+	for i := 0; i < n; i++ {
+		if err := agg.Add(ctx, fmt.Sprintf("s3://src/many/%d.log", i), func() {
+			mu.Lock()
+			acked[i]++
+			mu.Unlock()
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if err := agg.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if acked[i] != 1 {
+			t.Errorf("source %d is acked %d times", i, acked[i])
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package router
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+func loadAWSConfig(ctx context.Context, opt *Option) (aws.Config, error) {
+	var loadOpts []func(*config.LoadOptions) error
+	if opt.Region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(opt.Region))
+	}
+	if opt.Profile != "" {
+		loadOpts = append(loadOpts, config.WithSharedConfigProfile(opt.Profile))
+	}
+	return config.LoadDefaultConfig(ctx, loadOpts...)
+}
+
+// newS3Client creates a S3 client. When roleARN is not empty, the client assumes the role.
+func newS3Client(awsConf aws.Config, opt *Option, roleARN string) *s3.Client {
+	if roleARN != "" {
+		awsConf = awsConf.Copy()
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConf), roleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = "s3-object-router"
+		})
+		awsConf.Credentials = aws.NewCredentialsCache(provider)
+	}
+	return s3.NewFromConfig(awsConf, func(o *s3.Options) {
+		if opt.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opt.Endpoint)
+		}
+		o.UsePathStyle = opt.PathStyle
+	})
+}
@@ -0,0 +1,184 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/kayac/s3-object-router/wildcard"
+	"golang.org/x/sync/errgroup"
+)
+
+// BackfillOption represents option values of Backfill
+type BackfillOption struct {
+	StartAfter     string    // process keys after this key
+	EndBefore      string    // process keys before this key
+	ModifiedAfter  time.Time // process objects modified after this time
+	ModifiedBefore time.Time // process objects modified before this time
+	Include        []string  // wildcard patterns of keys to process
+	Exclude        []string  // wildcard patterns of keys not to process
+	Parallelism    int       // number of objects processed concurrently
+	CheckpointFile string    // file to save the last processed key for resuming
+}
+
+func (opt *BackfillOption) match(obj backfillObject) bool {
+	if strings.HasSuffix(obj.key, "/") {
+		return false
+	}
+	if opt.StartAfter != "" && obj.key <= opt.StartAfter {
+		return false
+	}
+	if opt.EndBefore != "" && obj.key >= opt.EndBefore {
+		return false
+	}
+	if !opt.ModifiedAfter.IsZero() && !obj.lastModified.After(opt.ModifiedAfter) {
+		return false
+	}
+	if !opt.ModifiedBefore.IsZero() && !obj.lastModified.Before(opt.ModifiedBefore) {
+		return false
+	}
+	for _, pattern := range opt.Exclude {
+		if wildcard.Match(pattern, obj.key) {
+			return false
+		}
+	}
+	if len(opt.Include) == 0 {
+		return true
+	}
+	for _, pattern := range opt.Include {
+		if wildcard.Match(pattern, obj.key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opt *BackfillOption) readCheckpoint() (string, error) {
+	if opt.CheckpointFile == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(opt.CheckpointFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (opt *BackfillOption) writeCheckpoint(checkpoint string) error {
+	if opt.CheckpointFile == "" || checkpoint == "" {
+		return nil
+	}
+	return os.WriteFile(opt.CheckpointFile, []byte(checkpoint+"\n"), 0644)
+}
+
+func (opt *BackfillOption) removeCheckpoint() error {
+	if opt.CheckpointFile == "" {
+		return nil
+	}
+	if err := os.Remove(opt.CheckpointFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type backfillObject struct {
+	key          string
+	lastModified time.Time
+}
+
+// Backfill lists objects under the prefix URL and routes every object.
+// Objects are processed page by page. When the CheckpointFile is set,
+// the last key of each completed page is saved and a next Backfill resumes from it.
+func (r *Router) Backfill(ctx context.Context, prefixURL string, opt *BackfillOption) error {
+	scheme, bucket, prefix, err := parseObjectURL(prefixURL)
+	if err != nil {
+		return err
+	}
+	storage, ok := r.sources[scheme]
+	if !ok {
+		return fmt.Errorf("unsupported scheme %s://", scheme)
+	}
+	startAfter := opt.StartAfter
+	if checkpoint, err := opt.readCheckpoint(); err != nil {
+		return err
+	} else if checkpoint > startAfter {
+		log.Println("[info] resume backfill after", checkpoint)
+		startAfter = checkpoint
+	}
+
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	if startAfter != "" {
+		in.StartAfter = aws.String(startAfter)
+	}
+	var processed, skipped int
+	for {
+		out, err := storage.List(ctx, in)
+		if err != nil {
+			return err
+		}
+		objs := make([]backfillObject, 0, len(out.Contents))
+		for _, content := range out.Contents {
+			objs = append(objs, backfillObject{
+				key:          aws.ToString(content.Key),
+				lastModified: aws.ToTime(content.LastModified),
+			})
+		}
+		p, s, err := r.backfillObjects(ctx, scheme, bucket, objs, opt)
+		processed, skipped = processed+p, skipped+s
+		if err != nil {
+			return err
+		}
+		var lastKey string
+		if len(objs) > 0 {
+			lastKey = objs[len(objs)-1].key
+		}
+		if err := opt.writeCheckpoint(lastKey); err != nil {
+			return err
+		}
+		if (opt.EndBefore != "" && lastKey >= opt.EndBefore) || !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+	log.Printf("[info] backfill completed. %d objects processed, %d objects skipped", processed, skipped)
+	return opt.removeCheckpoint()
+}
+
+// backfillObjects routes objects concurrently, and returns numbers of processed and skipped objects.
+func (r *Router) backfillObjects(ctx context.Context, scheme, bucket string, objs []backfillObject, opt *BackfillOption) (int, int, error) {
+	parallelism := opt.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	eg := errgroup.Group{}
+	eg.SetLimit(parallelism)
+	var processed, skipped int
+	for _, obj := range objs {
+		if !opt.match(obj) {
+			skipped++
+			continue
+		}
+		processed++
+		eg.Go(func() error {
+			err := r.Run(ctx, objectURL(scheme, bucket, obj.key))
+			if errors.Is(err, ErrRoutedObject) {
+				log.Println("[warn]", err)
+				return nil
+			}
+			return err
+		})
+	}
+	return processed, skipped, eg.Wait()
+}
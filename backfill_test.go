@@ -0,0 +1,144 @@
+package router_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/go-cmp/cmp"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	for _, key := range []string{"logs/a.log", "logs/b.log", "logs/c.log", "logs/d.txt", "other/e.log"} {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(`{"tag":"app"}` + "\n"),
+		})
+	}
+	opt := testRouterOption("dest")
+	opt.KeyPrefix = "routed/{{ .tag }}"
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	// resume after a.log
+	if err := os.WriteFile(checkpoint, []byte("logs/a.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = r.Backfill(ctx, "s3://src/logs/", &router.BackfillOption{
+		Include:        []string{"*.log"},
+		Exclude:        []string{"*/c.*"},
+		Parallelism:    2,
+		CheckpointFile: checkpoint,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range list.Contents {
+		keys = append(keys, *obj.Key)
+	}
+	if d := cmp.Diff([]string{"routed/app/b.log"}, keys); d != "" {
+		t.Error("unexpected routed objects:", d)
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Error("checkpoint file must be removed after completed", err)
+	}
+}
+
+func TestBackfillInventory(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	for _, key := range []string{"logs/a b.log", "logs/b.log", "logs/c.log"} {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(`{"tag":"app"}` + "\n"),
+		})
+	}
+	var data bytes.Buffer
+	gw := gzip.NewWriter(&data)
+	io.WriteString(gw, `"src","logs/a+b.log","2024-01-01T00:00:00.000Z"
+"src","logs/b.log","2024-01-02T00:00:00.000Z"
+"src","logs/c.log","2024-01-03T00:00:00.000Z"
+`)
+	gw.Close()
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("inventory"),
+		Key:    aws.String("src/config/data/1.csv.gz"),
+		Body:   &data,
+	})
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("inventory"),
+		Key:    aws.String("src/config/2024-01-04T00-00Z/manifest.json"),
+		Body: strings.NewReader(`{
+  "sourceBucket": "src",
+  "destinationBucket": "arn:aws:s3:::inventory",
+  "fileFormat": "CSV",
+  "fileSchema": "Bucket, Key, LastModifiedDate",
+  "files": [{"key": "src/config/data/1.csv.gz"}]
+}`),
+	})
+
+	opt := testRouterOption("dest")
+	opt.KeyPrefix = "routed/{{ .tag }}"
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	err = r.BackfillInventory(ctx, "s3://inventory/src/config/2024-01-04T00-00Z/manifest.json", &router.BackfillOption{
+		ModifiedBefore: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range list.Contents {
+		keys = append(keys, *obj.Key)
+	}
+	if d := cmp.Diff([]string{"routed/app/a b.log", "routed/app/b.log"}, keys); d != "" {
+		t.Error("unexpected routed objects:", d)
+	}
+
+	// a checkpoint which is not in the manifest must not skip all files
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	if err := os.WriteFile(checkpoint, []byte("logs/b.log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = r.BackfillInventory(ctx, "s3://inventory/src/config/2024-01-04T00-00Z/manifest.json", &router.BackfillOption{
+		CheckpointFile: checkpoint,
+	})
+	if err == nil {
+		t.Error("stale checkpoint must be an error")
+	}
+	if _, err := os.Stat(checkpoint); err != nil {
+		t.Error("checkpoint file must be kept", err)
+	}
+}
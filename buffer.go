@@ -3,28 +3,100 @@ package router
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 )
 
-type buffer interface {
+// Buffer is a buffer of encoded records of a destination object.
+type Buffer interface {
 	Write([]byte) (int, error)
 	Bytes() []byte
 }
 
-type gzBuffer struct {
+// compression represents an output compression format.
+type compression struct {
+	name        string
+	suffix      string
+	contentType string
+	newWriter   func(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+var compressions = map[string]compression{
+	compressionNone: {
+		name: compressionNone,
+	},
+	compressionGzip: {
+		name:        compressionGzip,
+		suffix:      ".gz",
+		contentType: "application/gzip",
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		},
+	},
+	compressionZstd: {
+		name:        compressionZstd,
+		suffix:      ".zst",
+		contentType: "application/zstd",
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			opts := []zstd.EOption{}
+			if level != 0 {
+				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+			}
+			return zstd.NewWriter(w, opts...)
+		},
+	},
+	compressionSnappy: {
+		name:        compressionSnappy,
+		suffix:      ".sz",
+		contentType: "application/x-snappy-framed",
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level != 0 {
+				return nil, fmt.Errorf("snappy does not support compression level")
+			}
+			return snappy.NewBufferedWriter(w), nil
+		},
+	},
+	compressionBzip2: {
+		name:        compressionBzip2,
+		suffix:      ".bz2",
+		contentType: "application/x-bzip2",
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+		},
+	},
+}
+
+// compressedBuffer is a Buffer which compresses written bytes.
+// Bytes() returns complete compressed bytes after Close() is called.
+type compressedBuffer struct {
 	bytes.Buffer
-	gz *gzip.Writer
+	w io.WriteCloser
 }
 
-func newGzipBuffer() buffer {
-	buf := &gzBuffer{}
-	buf.gz = gzip.NewWriter(&buf.Buffer)
-	return buf
+func newCompressedBuffer(c compression, level int) (Buffer, error) {
+	if c.newWriter == nil {
+		return new(bytes.Buffer), nil
+	}
+	buf := &compressedBuffer{}
+	w, err := c.newWriter(&buf.Buffer, level)
+	if err != nil {
+		return nil, err
+	}
+	buf.w = w
+	return buf, nil
 }
 
-func (buf *gzBuffer) Write(p []byte) (int, error) {
-	return buf.gz.Write(p)
+func (buf *compressedBuffer) Write(p []byte) (int, error) {
+	return buf.w.Write(p)
 }
 
-func (buf *gzBuffer) Close() error {
-	return buf.gz.Close()
+func (buf *compressedBuffer) Close() error {
+	return buf.w.Close()
 }
@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+	"time"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+var (
+	backfillOption                   router.BackfillOption
+	modifiedAfter, modifiedBefore    string
+	includePatterns, excludePatterns string
+	inventory                        bool
+)
+
+func backfillFlags(fs *flag.FlagSet) {
+	fs.StringVar(&backfillOption.StartAfter, "start-after", "", "backfill objects which have keys after this key")
+	fs.StringVar(&backfillOption.EndBefore, "end-before", "", "backfill objects which have keys before this key")
+	fs.StringVar(&modifiedAfter, "modified-after", "", "backfill objects modified after this time (RFC3339)")
+	fs.StringVar(&modifiedBefore, "modified-before", "", "backfill objects modified before this time (RFC3339)")
+	fs.StringVar(&includePatterns, "include", "", "comma separated wildcard patterns of keys to backfill")
+	fs.StringVar(&excludePatterns, "exclude", "", "comma separated wildcard patterns of keys not to backfill")
+	fs.IntVar(&backfillOption.Parallelism, "parallelism", 1, "number of objects processed concurrently")
+	fs.StringVar(&backfillOption.CheckpointFile, "checkpoint", "", "checkpoint file to resume an interrupted backfill")
+	fs.BoolVar(&inventory, "inventory", false, "args are manifest.json URLs of S3 Inventory instead of prefixes")
+}
+
+func backfill(r *router.Router, args []string) error {
+	if len(args) == 0 {
+		return errors.New("backfill requires source prefix URLs. e.g. s3://bucket/prefix/")
+	}
+	var err error
+	if modifiedAfter != "" {
+		if backfillOption.ModifiedAfter, err = time.Parse(time.RFC3339, modifiedAfter); err != nil {
+			return err
+		}
+	}
+	if modifiedBefore != "" {
+		if backfillOption.ModifiedBefore, err = time.Parse(time.RFC3339, modifiedBefore); err != nil {
+			return err
+		}
+	}
+	backfillOption.Include = splitPatterns(includePatterns)
+	backfillOption.Exclude = splitPatterns(excludePatterns)
+	run := r.Backfill
+	if inventory {
+		run = r.BackfillInventory
+	}
+	for _, u := range args {
+		if err := run(context.Background(), u, &backfillOption); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitPatterns(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
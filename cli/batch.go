@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	router "github.com/kayac/s3-object-router"
+)
+
+// result codes of S3 Batch Operations
+const (
+	batchSucceeded        = "Succeeded"
+	batchTemporaryFailure = "TemporaryFailure"
+	batchPermanentFailure = "PermanentFailure"
+)
+
+// batchTask represents a task of S3 Batch Operations invocation schema 1.0 and 2.0.
+type batchTask struct {
+	TaskID      string `json:"taskId"`
+	S3Key       string `json:"s3Key"`
+	S3BucketARN string `json:"s3BucketArn"` // 1.0
+	S3Bucket    string `json:"s3Bucket"`    // 2.0
+}
+
+type batchEvent struct {
+	InvocationSchemaVersion string      `json:"invocationSchemaVersion"`
+	InvocationID            string      `json:"invocationId"`
+	Tasks                   []batchTask `json:"tasks"`
+}
+
+func batchHandler(ctx context.Context, r *router.Router, event batchEvent) events.S3BatchJobResponse {
+	res := events.S3BatchJobResponse{
+		InvocationSchemaVersion: event.InvocationSchemaVersion,
+		TreatMissingKeysAs:      batchPermanentFailure,
+		InvocationID:            event.InvocationID,
+	}
+	for _, task := range event.Tasks {
+		bucket := task.S3Bucket
+		if bucket == "" {
+			bucket = task.S3BucketARN[strings.LastIndex(task.S3BucketARN, ":")+1:]
+		}
+		result := events.S3BatchJobResult{
+			TaskID:     task.TaskID,
+			ResultCode: batchSucceeded,
+		}
+		key, err := url.QueryUnescape(task.S3Key)
+		if err == nil {
+			u := url.URL{Scheme: "s3", Host: bucket, Path: key}
+			err = r.Run(ctx, u.String())
+		}
+		if err != nil {
+			log.Println("[error]", err)
+			result.ResultCode = batchResultCode(err)
+			result.ResultString = err.Error()
+		}
+		res.Results = append(res.Results, result)
+	}
+	return res
+}
+
+// batchResultCode returns PermanentFailure for errors which will not be resolved by retries.
+// A missing object is reported as NotFound by HeadObject of the loop guard, or NoSuchKey by GetObject.
+func batchResultCode(err error) string {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	var urlErr url.EscapeError
+	switch {
+	case errors.As(err, &noSuchKey), errors.As(err, &notFound), errors.Is(err, router.ErrRoutedObject), errors.As(err, &urlErr):
+		return batchPermanentFailure
+	default:
+		return batchTemporaryFailure
+	}
+}
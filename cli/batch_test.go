@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/go-cmp/cmp"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func testRouter(t *testing.T) (*router.Router, *router.MemoryStorage) {
+	t.Helper()
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	for _, key := range []string{"logs/a b.log", "logs/b.log"} {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(`{"tag":"app"}` + "\n"),
+		})
+	}
+	// an already routed object
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String("src"),
+		Key:      aws.String("routed.log"),
+		Body:     strings.NewReader(`{"tag":"app"}` + "\n"),
+		Metadata: map[string]string{router.MetaHeaderName: "s3://src/original.log"},
+	})
+	r, err := router.New(&router.Option{
+		Bucket:           "dest",
+		KeyPrefix:        "{{ .tag }}",
+		PutS3:            true,
+		KeepOriginalName: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	return r, storage
+}
+
+func TestLambdaHandlerBatch(t *testing.T) {
+	for _, version := range []string{"1.0", "2.0"} {
+		r, storage := testRouter(t)
+		tasks := []map[string]string{
+			{"taskId": "t1", "s3Key": "logs/a%20b.log"},
+			{"taskId": "t2", "s3Key": "routed.log"},
+			{"taskId": "t3", "s3Key": "logs/%zz"},
+			{"taskId": "t4", "s3Key": "logs/missing.log"},
+		}
+		for _, task := range tasks {
+			if version == "1.0" {
+				task["s3BucketArn"] = "arn:aws:s3:::src"
+			} else {
+				task["s3Bucket"] = "src"
+			}
+		}
+		payload, _ := json.Marshal(map[string]interface{}{
+			"invocationSchemaVersion": version,
+			"invocationId":            "inv",
+			"tasks":                   tasks,
+		})
+		out, err := lambdaHandler(r)(context.Background(), payload)
+		if err != nil {
+			t.Fatal(version, err)
+		}
+		res := out.(events.S3BatchJobResponse)
+		codes := make(map[string]string)
+		for _, result := range res.Results {
+			codes[result.TaskID] = result.ResultCode
+		}
+		expected := map[string]string{
+			"t1": batchSucceeded,
+			"t2": batchPermanentFailure,
+			"t3": batchPermanentFailure,
+			"t4": batchPermanentFailure,
+		}
+		if d := cmp.Diff(expected, codes); d != "" {
+			t.Errorf("%s: unexpected results: %s", version, d)
+		}
+		if res.InvocationSchemaVersion != version || res.InvocationID != "inv" {
+			t.Errorf("%s: unexpected response %#v", version, res)
+		}
+		if storage.Object("dest", "app/a b.log") == nil {
+			t.Errorf("%s: a task is not routed", version)
+		}
+	}
+}
+
+func TestLambdaHandlerS3Event(t *testing.T) {
+	r, storage := testRouter(t)
+	payload := `{"Records":[
+{"s3":{"bucket":{"name":"src"},"object":{"key":"logs/a+b.log"}}},
+{"s3":{"bucket":{"name":"src"},"object":{"key":"logs/b.log"}}},
+{"s3":{"bucket":{"name":"src"},"object":{"key":"routed.log"}}}
+]}`
+	out, err := lambdaHandler(r)(context.Background(), json.RawMessage(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil {
+		t.Errorf("unexpected response %#v", out)
+	}
+	for _, key := range []string{"app/a b.log", "app/b.log"} {
+		if storage.Object("dest", key) == nil {
+			t.Errorf("%s is not routed", key)
+		}
+	}
+	if storage.Object("dest", "app/routed.log") != nil {
+		t.Error("routed object must not be routed again")
+	}
+}
+
+func TestLambdaHandlerS3EventError(t *testing.T) {
+	r, _ := testRouter(t)
+	payload := `{"Records":[
+{"s3":{"bucket":{"name":"src"},"object":{"key":"routed.log"}}},
+{"s3":{"bucket":{"name":"src"},"object":{"key":"logs/missing.log"}}}
+]}`
+	_, err := lambdaHandler(r)(context.Background(), json.RawMessage(payload))
+	if err == nil || errors.Is(err, router.ErrRoutedObject) {
+		t.Errorf("only errors of not routed objects must be returned: %v", err)
+	}
+}
+
+func TestBatchResultCode(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{&types.NoSuchKey{}, batchPermanentFailure},
+		{fmt.Errorf("head: %w", &types.NotFound{}), batchPermanentFailure},
+		{fmt.Errorf("s3://src/x %w", router.ErrRoutedObject), batchPermanentFailure},
+		{errors.New("slow down"), batchTemporaryFailure},
+	}
+	for _, c := range cases {
+		if code := batchResultCode(c.err); code != c.expected {
+			t.Errorf("%v: unexpected result code %s", c.err, code)
+		}
+	}
+}
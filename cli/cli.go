@@ -0,0 +1,348 @@
+// Package cli implements s3-object-router command.
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	router "github.com/kayac/s3-object-router"
+)
+
+// subcommands of CLI
+const (
+	commandRoute    = "route"
+	commandBackfill = "backfill"
+	commandTrace    = "trace"
+	commandRollback = "rollback"
+	commandExplain  = "explain"
+	commandValidate = "validate"
+	commandTest     = "test"
+	commandServe    = "serve"
+	commandCompact  = "compact"
+)
+
+type subcommand struct {
+	run        func(*router.Router, []string) error
+	flags      func(*flag.FlagSet) // defines flags only for the subcommand
+	standalone bool                // runs without a Router configured by flags
+}
+
+var commands = map[string]subcommand{
+	commandRoute:    {run: cli},
+	commandBackfill: {run: backfill, flags: backfillFlags},
+	commandTrace:    {run: trace},
+	commandRollback: {run: rollback, flags: rollbackFlags},
+	commandExplain:  {run: explain},
+	commandValidate: {run: validate},
+	commandTest:     {run: test, flags: testFlags, standalone: true},
+	commandServe:    {run: serve, flags: serveFlags},
+	commandCompact:  {run: compact, flags: compactFlags},
+}
+
+// Main runs s3-object-router command. Parsers, encoders and template functions
+// registered before Main are available by options.
+func Main() {
+	command, args := parseCommand(os.Args[1:])
+	if f := commands[command].flags; f != nil {
+		f(flag.CommandLine)
+	}
+	if commands[command].standalone {
+		flag.CommandLine.Parse(args)
+		if err := commands[command].run(nil, flag.Args()); err != nil {
+			log.Println("[error]", err)
+			os.Exit(1)
+		}
+		return
+	}
+	r, err := setup(args)
+	if err != nil {
+		log.Println("[error]", err)
+		os.Exit(1)
+	}
+
+	if strings.HasPrefix(os.Getenv("AWS_EXECUTION_ENV"), "AWS_Lambda") ||
+		os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(lambdaHandler(r))
+		return
+	}
+	if err := commands[command].run(r, flag.Args()); err != nil {
+		log.Println("[error]", err)
+		os.Exit(1)
+	}
+}
+
+// parseCommand returns a subcommand name and rest of args.
+// The subcommand defaults to route for backward compatibility.
+func parseCommand(args []string) (string, []string) {
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			return args[0], args[1:]
+		}
+	}
+	return commandRoute, args
+}
+
+// lambdaHandler handles S3 event notifications and S3 Batch Operations invocations.
+func lambdaHandler(r *router.Router) func(context.Context, json.RawMessage) (interface{}, error) {
+	return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+		var batch batchEvent
+		if err := json.Unmarshal(payload, &batch); err != nil {
+			return nil, err
+		}
+		if batch.InvocationSchemaVersion != "" {
+			return batchHandler(ctx, r, batch), nil
+		}
+		var event events.S3Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		return nil, s3EventHandler(ctx, r, event)
+	}
+}
+
+func s3EventHandler(ctx context.Context, r *router.Router, event events.S3Event) error {
+	srcs := make([]string, 0, len(event.Records))
+	for _, record := range event.Records {
+		u := url.URL{
+			Scheme: "s3",
+			Host:   record.S3.Bucket.Name,
+			Path:   record.S3.Object.URLDecodedKey,
+		}
+		srcs = append(srcs, u.String())
+	}
+	// routed objects which trigger the function again are skipped, not to be retried
+	if err := skipRoutedObjects(r.RunAll(ctx, srcs)); err != nil {
+		log.Println("[error]", err)
+		return err
+	}
+	return nil
+}
+
+// skipRoutedObjects logs and removes router.ErrRoutedObject from errors joined by RunAll.
+func skipRoutedObjects(err error) error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		if errors.Is(err, router.ErrRoutedObject) {
+			log.Println("[warn]", err)
+			return nil
+		}
+		return err
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		if errors.Is(e, router.ErrRoutedObject) {
+			log.Println("[warn]", e)
+			continue
+		}
+		errs = append(errs, e)
+	}
+	return errors.Join(errs...)
+}
+
+func setup(args []string) (*router.Router, error) {
+	var (
+		bucket, keyPrefix, replacer, parser, objFromat string
+		timeKey, timeFormat, timeZone                  string
+		sourceCompression, compression                 string
+		compressionLevel                               int
+		contentType, cacheControl, contentDisposition  string
+		contentEncoding, bucketKey                     bool
+		storageClass, sse, sseKMSKeyID, acl            string
+		expectedBucketOwner, tags                      string
+		endpoint, region, profile                      string
+		sourceRoleARN, destinationRoleARN              string
+		pathStyle, skipUnchanged                       bool
+		putRetries                                     int
+		putRetryWait, writePolicy                      string
+		sourceAction, archiveTo                        string
+		metaHeaderName, sourceTrigger, lineageTo       string
+		maxConcurrency, sourceConcurrency              int
+		maxObjectBytes, maxObjectRecords               int
+		sortByTime                                     bool
+		sortSpillRecords                               int
+		gzip, timeParse, localTime, noPut, keep        bool
+	)
+	flag.StringVar(&bucket, "bucket", "", "destination S3 bucket name, or file:///path/to/dir for local directory")
+	flag.StringVar(&keyPrefix, "key-prefix", "", "prefix of S3 key")
+	flag.BoolVar(&gzip, "gzip", true, "compress destination object by gzip")
+	flag.StringVar(&replacer, "replacer", "", `wildcard string replacer JSON. e.g. {"foo.bar.*":"foo"}`)
+	flag.StringVar(&parser, "parser", "json", "object record parser. choices are json|cloudfront")
+	flag.BoolVar(&timeParse, "time-parse", false, "parse record value as time.Time with -time-format")
+	flag.StringVar(&timeFormat, "time-format", time.RFC3339Nano, "format of time-parse")
+	flag.StringVar(&timeKey, "time-key", router.DefaultTimeKey, "record key name for time-parse")
+	flag.BoolVar(&localTime, "local-time", false, "set time zone to localtime for parsed time")
+	flag.StringVar(&timeZone, "time-zone", "", `set time zone to specified one for parsed time. e.g. "America/Los_Angeles" if use with -local-time,  -local-time takes precedence`)
+	flag.BoolVar(&noPut, "no-put", false, "do not put to s3")
+	flag.BoolVar(&keep, "keep-original-name", false, "keep original object base name")
+	flag.StringVar(&objFromat, "format", "none", `convert the s3 object format. choices are json|none`)
+	flag.IntVar(&maxObjectBytes, "max-object-bytes", 0, "roll over a destination to a new part when its size exceeds this bytes. 0 means no limit")
+	flag.IntVar(&maxObjectRecords, "max-object-records", 0, "roll over a destination to a new part when its number of records exceeds this. 0 means no limit")
+	flag.BoolVar(&sortByTime, "sort-by-time", false, "sort records of each destination by -time-key. requires -time-parse")
+	flag.IntVar(&sortSpillRecords, "sort-spill-records", router.DefaultSortSpillRecords, "number of records of each destination kept in memory for -sort-by-time. more records are spilled to temporary files")
+	flag.StringVar(&sourceCompression, "source-compression", "auto", "compression of the source object. choices are auto|none|gzip|zstd|bzip2|xz|snappy|zip")
+	flag.StringVar(&compression, "compression", "", "compress destination object. choices are none|gzip|zstd|snappy|bzip2. takes precedence over -gzip")
+	flag.IntVar(&compressionLevel, "compression-level", 0, "compression level. 0 means default level of the compression")
+	flag.StringVar(&contentType, "content-type", "", "Content-Type of destination object. default is derived from -format and -compression")
+	flag.BoolVar(&contentEncoding, "content-encoding", false, "set Content-Encoding header for -compression instead of key suffix. supports gzip|zstd")
+	flag.StringVar(&cacheControl, "cache-control", "", "Cache-Control of destination object")
+	flag.StringVar(&contentDisposition, "content-disposition", "", "Content-Disposition of destination object")
+	flag.StringVar(&storageClass, "storage-class", "", "storage class of destination object. e.g. STANDARD_IA (Go template)")
+	flag.StringVar(&sse, "sse", "", "server-side encryption of destination object. choices are AES256|aws:kms|aws:kms:dsse (Go template)")
+	flag.StringVar(&sseKMSKeyID, "sse-kms-key-id", "", "KMS key ID for -sse aws:kms (Go template)")
+	flag.BoolVar(&bucketKey, "bucket-key", false, "use S3 Bucket Key for SSE-KMS")
+	flag.StringVar(&acl, "acl", "", "canned ACL of destination object. e.g. bucket-owner-full-control (Go template)")
+	flag.StringVar(&expectedBucketOwner, "expected-bucket-owner", "", "account ID of the expected destination bucket owner")
+	flag.StringVar(&tags, "tags", "", `object tags JSON. values are Go template. e.g. {"app":"{{ .tag }}"}`)
+	flag.StringVar(&endpoint, "endpoint", "", "custom S3 endpoint URL. e.g. http://localhost:9000")
+	flag.BoolVar(&pathStyle, "path-style", false, "use path-style addressing for S3")
+	flag.StringVar(&region, "region", "", "AWS region")
+	flag.StringVar(&profile, "profile", "", "AWS shared config profile")
+	flag.StringVar(&sourceRoleARN, "source-role-arn", "", "IAM role ARN to assume for reading source objects")
+	flag.StringVar(&destinationRoleARN, "destination-role-arn", "", "IAM role ARN to assume for writing destination objects")
+	flag.IntVar(&putRetries, "put-retries", 0, "number of retries for failed puts")
+	flag.StringVar(&putRetryWait, "put-retry-wait", router.DefaultPutRetryWait.String(), "base duration of exponential backoff between retries of put")
+	flag.BoolVar(&skipUnchanged, "skip-unchanged", false, "skip put to destinations which already have the same content")
+	flag.StringVar(&writePolicy, "write-policy", router.WritePolicyOverwrite, "policy for existing destination objects. choices are overwrite|skip-if-exists|fail-if-exists")
+	flag.StringVar(&sourceAction, "source-action", router.SourceActionNone, "action for the source object after all destinations are written. choices are none|delete|archive|tag")
+	flag.StringVar(&archiveTo, "archive-to", "", "archive destination URL for -source-action archive. e.g. s3://archive-bucket/prefix/")
+	flag.StringVar(&metaHeaderName, "meta-header-name", router.MetaHeaderName, "metadata name to set the original source URL to routed objects, used for loop guard")
+	flag.IntVar(&maxConcurrency, "max-concurrency", router.MaxConcurrency, "maximum concurrency of puts")
+	flag.IntVar(&sourceConcurrency, "source-concurrency", 1, "number of source objects routed concurrently")
+	flag.StringVar(&sourceTrigger, "source-trigger", "", "URL prefix of source objects which trigger the router. e.g. s3://bucket/prefix/. fails at startup when destinations can overlap it")
+	flag.StringVar(&lineageTo, "lineage-to", "", "URL prefix to write lineage JSON of each source object. e.g. s3://bucket/lineage/")
+	flag.VisitAll(envToFlag)
+	flag.CommandLine.Parse(args)
+
+	opt := router.Option{
+		Bucket:           bucket,
+		KeyPrefix:        keyPrefix,
+		Gzip:             gzip,
+		Replacer:         replacer,
+		Parser:           parser,
+		TimeParse:        timeParse,
+		TimeKey:          timeKey,
+		TimeFormat:       timeFormat,
+		LocalTime:        localTime,
+		TimeZone:         timeZone,
+		PutS3:            !noPut,
+		KeepOriginalName: keep,
+		ObjectFormat:     objFromat,
+
+		MaxObjectBytes:   maxObjectBytes,
+		MaxObjectRecords: maxObjectRecords,
+
+		SortByTime:       sortByTime,
+		SortSpillRecords: sortSpillRecords,
+
+		SourceCompression: sourceCompression,
+		Compression:       compression,
+		CompressionLevel:  compressionLevel,
+
+		ContentType:        contentType,
+		ContentEncoding:    contentEncoding,
+		CacheControl:       cacheControl,
+		ContentDisposition: contentDisposition,
+
+		StorageClass:        storageClass,
+		SSE:                 sse,
+		SSEKMSKeyID:         sseKMSKeyID,
+		BucketKeyEnabled:    bucketKey,
+		ACL:                 acl,
+		ExpectedBucketOwner: expectedBucketOwner,
+		Tags:                tags,
+
+		Endpoint:           endpoint,
+		PathStyle:          pathStyle,
+		Region:             region,
+		Profile:            profile,
+		SourceRoleARN:      sourceRoleARN,
+		DestinationRoleARN: destinationRoleARN,
+
+		PutRetries:    putRetries,
+		PutRetryWait:  putRetryWait,
+		SkipUnchanged: skipUnchanged,
+		WritePolicy:   writePolicy,
+
+		SourceAction: sourceAction,
+		ArchiveTo:    archiveTo,
+
+		MetaHeaderName:    metaHeaderName,
+		MaxConcurrency:    maxConcurrency,
+		SourceConcurrency: sourceConcurrency,
+		SourceTrigger:     sourceTrigger,
+
+		LineageTo: lineageTo,
+	}
+	log.Printf("[debug] option: %#v", opt)
+	return router.New(&opt)
+}
+
+func cli(r *router.Router, args []string) error {
+	if len(args) > 0 && args[0] == commandRoute {
+		// s3-object-router [options] route [sources]
+		args = args[1:]
+	}
+	ctx := context.Background()
+	var srcs []string
+	for _, arg := range args {
+		if arg == "-" {
+			// sources before stdin are routed first
+			if err := r.RunAll(ctx, srcs); err != nil {
+				return err
+			}
+			srcs = nil
+			if err := r.RunReader(ctx, os.Stdin, "stdin"); err != nil {
+				return err
+			}
+			continue
+		}
+		expanded, err := expandSource(arg)
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, expanded...)
+	}
+	return r.RunAll(ctx, srcs)
+}
+
+// expandSource converts local file paths with glob patterns to file:// URLs.
+func expandSource(arg string) ([]string, error) {
+	if strings.Contains(arg, "://") && !strings.HasPrefix(arg, "file://") {
+		return []string{arg}, nil
+	}
+	matches, err := filepath.Glob(strings.TrimPrefix(arg, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no such file", arg)
+	}
+	srcs := make([]string, 0, len(matches))
+	for _, m := range matches {
+		abs, err := filepath.Abs(m)
+		if err != nil {
+			return nil, err
+		}
+		u := url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+		srcs = append(srcs, u.String())
+	}
+	return srcs, nil
+}
+
+func envToFlag(f *flag.Flag) {
+	name := strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+	if s, ok := os.LookupEnv(name); ok {
+		f.Value.Set(s)
+	}
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestExpandSource(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(`{"tag":"local"}`+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logs := []string{
+		"file://" + filepath.ToSlash(filepath.Join(dir, "a.log")),
+		"file://" + filepath.ToSlash(filepath.Join(dir, "b.log")),
+	}
+	cases := []struct {
+		arg      string
+		expected []string
+	}{
+		{filepath.Join(dir, "*.log"), logs},
+		{"file://" + filepath.Join(dir, "*.log"), logs},
+		{filepath.Join(dir, "c.txt"), []string{"file://" + filepath.ToSlash(filepath.Join(dir, "c.txt"))}},
+		// remote URLs are passed through without expansion
+		{"s3://src/logs/*.log", []string{"s3://src/logs/*.log"}},
+	}
+	for _, c := range cases {
+		srcs, err := expandSource(c.arg)
+		if err != nil {
+			t.Fatal(c.arg, err)
+		}
+		if d := cmp.Diff(c.expected, srcs); d != "" {
+			t.Errorf("%s: unexpected sources: %s", c.arg, d)
+		}
+	}
+	if _, err := expandSource(filepath.Join(dir, "*.gz")); err == nil {
+		t.Error("a pattern which matches no files must be an error")
+	}
+}
+
+func TestCLIStdin(t *testing.T) {
+	r, storage := testRouter(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "x.log"), []byte(`{"tag":"local"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Create(filepath.Join(dir, "stdin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if _, err := stdin.WriteString(`{"tag":"stdin"}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stdin.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = orig }()
+
+	args := []string{commandRoute, filepath.Join(dir, "*.log"), "-", "s3://src/logs/b.log"}
+	if err := cli(r, args); err != nil {
+		t.Fatal(err)
+	}
+	// records of stdin are routed with the key base "stdin"
+	for _, key := range []string{"local/x.log", "stdin/stdin", "app/b.log"} {
+		if storage.Object("dest", key) == nil {
+			t.Errorf("%s is not routed", key)
+		}
+	}
+	if meta := storage.Object("dest", "stdin/stdin").Metadata; meta[router.MetaHeaderName] != "stdin" {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+}
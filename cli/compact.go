@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+var compactOption router.CompactOption
+
+func compactFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&compactOption.TargetBytes, "target-bytes", router.DefaultCompactTargetBytes, "total size of objects merged into one object")
+	fs.BoolVar(&compactOption.SortByTime, "sort", false, "sort records by the time key. requires -time-parse")
+}
+
+// compact merges small objects under each leaf prefix of the destination.
+func compact(r *router.Router, args []string) error {
+	if len(args) == 0 {
+		return errors.New("compact requires prefix URLs. e.g. s3://bucket/prefix/")
+	}
+	for _, u := range args {
+		if err := r.Compact(context.Background(), u, &compactOption); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func explain(r *router.Router, args []string) error {
+	if len(args) == 0 {
+		return errors.New("explain requires sample sources. e.g. s3://bucket/key, path/to/file or - for stdin")
+	}
+	ctx := context.Background()
+	for _, arg := range args {
+		if arg == "-" {
+			ex, err := r.ExplainReader(os.Stdin, "stdin")
+			if err != nil {
+				return err
+			}
+			if err := printExplanation(ex); err != nil {
+				return err
+			}
+			continue
+		}
+		srcs, err := expandSource(arg)
+		if err != nil {
+			return err
+		}
+		for _, src := range srcs {
+			ex, err := r.Explain(ctx, src)
+			if err != nil {
+				return err
+			}
+			if err := printExplanation(ex); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// printExplanation prints explanations of records as JSON lines, and a summary table of destinations.
+func printExplanation(ex *router.Explanation) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	var errs int
+	for _, rec := range ex.Records {
+		if rec.Error != "" {
+			errs++
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DESTINATION\tRECORDS\tBYTES")
+	for _, d := range ex.Destinations {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", d.Destination, d.Records, d.Bytes)
+	}
+	fmt.Fprintf(w, "(%d records, %d errors)\t\t\n", len(ex.Records), errs)
+	return w.Flush()
+}
@@ -0,0 +1,39 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+var rollbackExecute bool
+
+func rollbackFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&rollbackExecute, "execute", false, "delete objects. without this, objects to delete are only listed (dry-run)")
+}
+
+func rollback(r *router.Router, args []string) error {
+	if len(args) == 0 {
+		return errors.New("rollback requires source object URLs or prefixes. e.g. s3://bucket/key or s3://bucket/prefix/")
+	}
+	opt := &router.RollbackOption{DryRun: !rollbackExecute}
+	for _, u := range args {
+		urls, err := r.Rollback(context.Background(), u, opt)
+		for _, d := range urls {
+			fmt.Println(d)
+		}
+		if err != nil {
+			return err
+		}
+		if opt.DryRun {
+			log.Printf("[info] %d objects routed from %s. run with -execute to delete them", len(urls), u)
+		} else {
+			log.Printf("[info] %d objects routed from %s were deleted", len(urls), u)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+var serveOption router.ServeOption
+
+func serveFlags(fs *flag.FlagSet) {
+	fs.StringVar(&serveOption.QueueURL, "queue-url", "", "URL of SQS queue which receives S3 event notifications")
+	fs.StringVar(&serveOption.SQSEndpoint, "sqs-endpoint", "", "custom SQS endpoint URL. e.g. http://localhost:9324")
+	fs.IntVar(&serveOption.FlushBytes, "flush-bytes", 64*1024*1024, "flush a destination when its size exceeds this bytes")
+	fs.IntVar(&serveOption.FlushRecords, "flush-records", 0, "flush a destination when its number of records exceeds this. 0 means no limit")
+	fs.DurationVar(&serveOption.FlushInterval, "flush-interval", 5*time.Minute, "flush a destination when its age exceeds this duration")
+}
+
+// serve runs a daemon which aggregates source objects notified via SQS until SIGINT or SIGTERM.
+func serve(r *router.Router, _ []string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return r.Serve(ctx, &serveOption)
+}
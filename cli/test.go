@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/go-cmp/cmp"
+	router "github.com/kayac/s3-object-router"
+)
+
+var updateGolden bool
+
+func testFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&updateGolden, "update", false, "rewrite golden files by routed data")
+}
+
+// test runs golden test cases. Each arg is a directory of cases or a case directory which has config.json.
+func test(_ *router.Router, args []string) error {
+	if len(args) == 0 {
+		return errors.New("test requires directories of test cases. e.g. ./cases")
+	}
+	var dirs []string
+	for _, arg := range args {
+		if _, err := os.Stat(filepath.Join(arg, "config.json")); err == nil {
+			dirs = append(dirs, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*", "config.json"))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: no test cases", arg)
+		}
+		for _, m := range matches {
+			dirs = append(dirs, filepath.Dir(m))
+		}
+	}
+	sort.Strings(dirs)
+
+	var failed int
+	for _, dir := range dirs {
+		results, err := router.RunGoldenCase(dir, updateGolden)
+		if err != nil {
+			log.Printf("[error] %s: %s", dir, err)
+			failed++
+			continue
+		}
+		for _, res := range results {
+			switch {
+			case res.Updated:
+				fmt.Printf("UPDATED %s\n", res.GoldenFile)
+			case !res.Equal():
+				fmt.Printf("FAIL %s/%s\n%s\n", dir, res.Source, cmp.Diff(res.Expected, res.Routed))
+				failed++
+			default:
+				fmt.Printf("ok %s/%s\n", dir, res.Source)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d tests failed", failed)
+	}
+	return nil
+}
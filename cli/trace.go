@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func trace(r *router.Router, args []string) error {
+	if len(args) == 0 {
+		return errors.New("trace requires source object URLs. e.g. s3://bucket/key")
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	for _, u := range args {
+		l, err := r.Trace(context.Background(), u)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+// validate validates the configuration, and routes fixture records without put.
+// It fails when the configuration has problems or any fixture record can not be routed.
+func validate(r *router.Router, args []string) error {
+	var problems int
+	if err := r.Validate(); err != nil {
+		log.Println("[error]", err)
+		problems++
+	}
+	ctx := context.Background()
+	for _, arg := range args {
+		srcs, err := expandSource(arg)
+		if err != nil {
+			return err
+		}
+		for _, src := range srcs {
+			ex, err := r.Explain(ctx, src)
+			if err != nil {
+				return err
+			}
+			if len(ex.Records) == 0 {
+				log.Printf("[error] %s: no records", src)
+				problems++
+			}
+			for _, rec := range ex.Records {
+				if rec.Error != "" {
+					log.Printf("[error] %s:%d: %s", src, rec.Line, rec.Error)
+					problems++
+				}
+			}
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("validation failed with %d problems", problems)
+	}
+	log.Println("[info] configuration is valid")
+	return nil
+}
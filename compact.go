@@ -0,0 +1,265 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultCompactTargetBytes is the default target size of compacted objects.
+var DefaultCompactTargetBytes int64 = 128 * 1024 * 1024
+
+// CompactedMetaValue is the value of the loop guard metadata of compacted objects.
+var CompactedMetaValue = "compacted"
+
+// CompactOption represents option values of Compact
+type CompactOption struct {
+	TargetBytes int64 // total size of input objects merged into one object
+	SortByTime  bool  // sort records by the time key. requires time-parse
+}
+
+type compactObject struct {
+	key  string
+	size int64
+}
+
+// Compact merges objects under each leaf prefix (directory) of the prefix URL in the destination storage.
+// Objects are merged in key order up to TargetBytes of the total input size. Records are parsed by
+// the parser and written by the format and the compression of the router, so the format can be converted.
+// Input objects are deleted after the merged object is written. When any record fails to be parsed
+// or encoded, the inputs are kept. Without PutS3, objects to merge are only listed.
+func (r *Router) Compact(ctx context.Context, prefixURL string, opt *CompactOption) error {
+	scheme, bucket, prefix, err := parseObjectURL(prefixURL)
+	if err != nil {
+		return err
+	}
+	if scheme != r.option.destScheme {
+		return fmt.Errorf("compact supports only %s:// URLs of the destination storage", r.option.destScheme)
+	}
+	if opt.SortByTime && !r.option.TimeParse {
+		return errors.New("sorting by time requires time-parse")
+	}
+	target := opt.TargetBytes
+	if target <= 0 {
+		target = DefaultCompactTargetBytes
+	}
+
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	dirs := make(map[string][]compactObject)
+	for {
+		out, err := r.dest.List(ctx, in)
+		if err != nil {
+			return err
+		}
+		for _, content := range out.Contents {
+			key := aws.ToString(content.Key)
+			dirs[path.Dir(key)] = append(dirs[path.Dir(key)], compactObject{key: key, size: aws.ToInt64(content.Size)})
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			break
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+	names := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		names = append(names, dir)
+	}
+	sort.Strings(names)
+
+	var merged, inputs int
+	for _, dir := range names {
+		for _, objs := range compactGroups(dirs[dir], target) {
+			keys := make([]string, 0, len(objs))
+			for _, obj := range objs {
+				keys = append(keys, obj.key)
+			}
+			if !r.option.PutS3 {
+				log.Printf("[info] [dry-run] merge %d objects in %s", len(objs), objectURL(scheme, bucket, dir))
+				for _, key := range keys {
+					log.Println("[info] [dry-run]", objectURL(scheme, bucket, key))
+				}
+				continue
+			}
+			if err := r.compactObjects(ctx, bucket, dir, keys, opt); err != nil {
+				return err
+			}
+			merged++
+			inputs += len(objs)
+		}
+	}
+	log.Printf("[info] compaction completed. %d objects are merged into %d objects", inputs, merged)
+	return nil
+}
+
+// compactGroups groups objects in key order up to the target size.
+// Objects larger than the target and groups of a single object are not merged.
+func compactGroups(objs []compactObject, target int64) [][]compactObject {
+	sort.Slice(objs, func(i, j int) bool { return objs[i].key < objs[j].key })
+	var groups [][]compactObject
+	var group []compactObject
+	var size int64
+	flush := func() {
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+		group, size = nil, 0
+	}
+	for _, obj := range objs {
+		if obj.size >= target {
+			continue
+		}
+		if size+obj.size > target {
+			flush()
+		}
+		group = append(group, obj)
+		size += obj.size
+	}
+	flush()
+	return groups
+}
+
+// compactObjects merges the objects into a new object in the directory, and deletes them.
+// Records are streamed into the encoder, or into the sorter which spills them by sort-spill-records.
+func (r *Router) compactObjects(ctx context.Context, bucket, dir string, keys []string, opt *CompactOption) error {
+	var out *output
+	defer func() {
+		if out != nil && out.sorter != nil {
+			out.sorter.close()
+		}
+	}()
+	add := func(rec *Record) error {
+		if out == nil {
+			// attributes are rendered by the first record as routing
+			attrs, err := r.attributes.Render(rec)
+			if err != nil {
+				return err
+			}
+			out = &output{enc: r.option.newEncoder(), attrs: attrs}
+			if opt.SortByTime {
+				out.sorter = newRecordSorter(r.option.TimeKey, r.option.SortSpillRecords)
+			}
+		}
+		if out.sorter != nil {
+			return out.sorter.add(rec)
+		}
+		return r.encodeCompacted(out, rec)
+	}
+	for _, key := range keys {
+		if err := r.readRecords(ctx, bucket, key, opt.SortByTime, add); err != nil {
+			return fmt.Errorf("failed to read %s: %w", objectURL(r.option.destScheme, bucket, key), err)
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	if out.sorter != nil {
+		if err := out.sorter.each(func(rec *Record) error { return r.encodeCompacted(out, rec) }); err != nil {
+			return err
+		}
+	}
+
+	buf := out.enc.Buffer()
+	if c, isCloser := buf.(io.Closer); isCloser {
+		c.Close()
+	}
+	dest := destination{
+		Scheme: r.option.destScheme,
+		Bucket: bucket,
+		Key:    path.Join(dir, "compacted-"+uniqueKeyBase()) + r.option.keySuffix,
+	}
+	body := bytes.NewReader(buf.Bytes())
+	log.Printf("[info] merge %d objects into %s %d bytes %d records", len(keys), dest.String(), body.Len(), out.records)
+	meta := map[string]string{
+		r.option.MetaHeaderName: CompactedMetaValue,
+	}
+	res := &OutputResult{Destination: dest.String()}
+	r.putObjectWithRetry(ctx, dest, body, meta, out.attrs, res)
+	if res.Err != nil {
+		return fmt.Errorf("failed to put %s: %w", dest.String(), res.Err)
+	}
+	for _, key := range keys {
+		_, err := r.dest.Delete(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete %s merged into %s: %w", objectURL(r.option.destScheme, bucket, key), dest.String(), err)
+		}
+	}
+	return nil
+}
+
+func (r *Router) encodeCompacted(out *output, rec *Record) error {
+	if err := out.enc.Encode(rec); err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	out.count(rec, r.option.TimeKey)
+	return nil
+}
+
+// readRecords reads all records of the object, and calls fn for each record. It fails for records
+// which can not be parsed, not to lose any records by compaction.
+func (r *Router) readRecords(ctx context.Context, bucket, key string, parseTime bool, fn func(*Record) error) error {
+	out, err := r.dest.Get(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	entries, err := decompress(out.Body, compressionAuto)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := r.readEntryRecords(entry, parseTime, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Router) readEntryRecords(entry sourceEntry, parseTime bool, fn func(*Record) error) error {
+	src, err := entry.open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	parser := r.option.newParser()
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, initialBufSize), maxBufSize)
+	for line := 1; scanner.Scan(); line++ {
+		// scanner reuses the buffer
+		rs, err := parser.Parse(bytes.Clone(scanner.Bytes()))
+		if err == SkipLine {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		for _, rec := range rs {
+			if parseTime {
+				// routing keeps records with invalid time, but compaction must not replace their time by zero
+				if err := r.prepareRecord(rec, ""); err != nil {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+			}
+			if err := fn(rec); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,150 @@
+package router_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/go-cmp/cmp"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestCompact(t *testing.T) {
+	// records are sorted in memory, or spilled for every record
+	for _, spill := range []int{0, 1} {
+		testCompact(t, spill)
+	}
+}
+
+func testCompact(t *testing.T, spill int) {
+	t.Helper()
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	objects := map[string]string{
+		"app/2020-01-01/a.log": `{"tag":"app","datetime":"2020-01-01T00:00:03Z"}` + "\n",
+		"app/2020-01-01/b.log": `{"tag":"app","datetime":"2020-01-01T00:00:01Z"}` + "\n" + `{"tag":"app","datetime":"2020-01-01T00:00:04Z"}` + "\n",
+		"app/2020-01-01/c.log": `{"tag":"app","datetime":"2020-01-01T00:00:02Z"}` + "\n",
+		"app/2020-01-02/d.log": `{"tag":"app","datetime":"2020-01-02T00:00:00Z"}` + "\n",
+		"db/2020-01-01/e.log":  `{"tag":"db","datetime":"2020-01-01T00:00:00Z"}` + "\n",
+		"db/2020-01-01/f.log":  `{"tag":"db","datetime":"2020-01-01T00:00:00Z"}` + "\n",
+	}
+	for key, body := range objects {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("dest"),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(body),
+		})
+	}
+	opt := testRouterOption("dest")
+	opt.SortSpillRecords = spill
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetDestinationStorage(storage)
+	if err := r.Compact(ctx, "s3://dest/app/", &router.CompactOption{SortByTime: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	var merged *router.MemoryObject
+	for _, obj := range list.Contents {
+		key := *obj.Key
+		if strings.HasPrefix(key, "app/2020-01-01/compacted-") {
+			merged = storage.Object("dest", key)
+			key = "app/2020-01-01/compacted-*"
+		}
+		keys = append(keys, key)
+	}
+	// a single object in a directory and objects out of the prefix are kept
+	expected := []string{"app/2020-01-01/compacted-*", "app/2020-01-02/d.log", "db/2020-01-01/e.log", "db/2020-01-01/f.log"}
+	if d := cmp.Diff(expected, keys); d != "" {
+		t.Fatal("unexpected objects:", d)
+	}
+	sorted := `{"tag":"app","datetime":"2020-01-01T00:00:01Z"}
+{"tag":"app","datetime":"2020-01-01T00:00:02Z"}
+{"tag":"app","datetime":"2020-01-01T00:00:03Z"}
+{"tag":"app","datetime":"2020-01-01T00:00:04Z"}
+`
+	if d := cmp.Diff(sorted, string(merged.Body)); d != "" {
+		t.Error("unexpected merged body:", d)
+	}
+	if v := merged.Metadata[router.MetaHeaderName]; v != router.CompactedMetaValue {
+		t.Errorf("unexpected metadata %s", v)
+	}
+}
+
+func TestCompactTargetBytes(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	line := `{"tag":"app"}` + "\n" // 14 bytes
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("dest"),
+			Key:    aws.String("app/" + name + ".log"),
+			Body:   strings.NewReader(line),
+		})
+	}
+	r, err := router.New(testRouterOption("dest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetDestinationStorage(storage)
+	// a+b, c+d, and e is kept
+	if err := r.Compact(ctx, "s3://dest/app/", &router.CompactOption{TargetBytes: 28}); err != nil {
+		t.Fatal(err)
+	}
+	list, err := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compacted int
+	for _, obj := range list.Contents {
+		if strings.HasPrefix(*obj.Key, "app/compacted-") {
+			compacted++
+			if b := storage.Object("dest", *obj.Key).Body; string(b) != line+line {
+				t.Errorf("unexpected body of %s: %q", *obj.Key, b)
+			}
+		}
+	}
+	if compacted != 2 || len(list.Contents) != 3 || storage.Object("dest", "app/e.log") == nil {
+		t.Errorf("unexpected objects: %d compacted in %d", compacted, len(list.Contents))
+	}
+}
+
+func TestCompactInvalidRecord(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	for key, body := range map[string]string{
+		"app/a.log": `{"tag":"app","datetime":"2020-01-01T00:00:00Z"}` + "\n",
+		"app/b.log": `{"tag":"app","datetime":"invalid"}` + "\n",
+	} {
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("dest"),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(body),
+		})
+	}
+	r, err := router.New(testRouterOption("dest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetDestinationStorage(storage)
+	if err := r.Compact(ctx, "s3://dest/app/", &router.CompactOption{SortByTime: true}); err == nil {
+		t.Error("invalid time must be an error")
+	}
+	list, err := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 2 {
+		t.Errorf("inputs must be kept: %d objects", len(list.Contents))
+	}
+}
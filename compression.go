@@ -0,0 +1,143 @@
+package router
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// EntryKey is a record key name to set an entry name of zip archived sources.
+var EntryKey = "source_entry"
+
+// source compression formats
+const (
+	compressionAuto   = "auto"
+	compressionNone   = "none"
+	compressionGzip   = "gzip"
+	compressionZstd   = "zstd"
+	compressionBzip2  = "bzip2"
+	compressionXz     = "xz"
+	compressionSnappy = "snappy"
+	compressionZip    = "zip"
+)
+
+var (
+	gzipMagicBytes   = []byte{0x1f, 0x8b}
+	zstdMagicBytes   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2MagicBytes  = []byte("BZh")
+	xzMagicBytes     = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	snappyMagicBytes = []byte("\xff\x06\x00\x00sNaPpY")
+	zipMagicBytes    = []byte("PK\x03\x04")
+	zipEmptyBytes    = []byte("PK\x05\x06")
+)
+
+// sourceEntry represents a decompressed stream in a source object.
+// name is not empty only for entries of zip archives.
+type sourceEntry struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+func isSourceCompression(c string) bool {
+	switch c {
+	case "", compressionAuto, compressionNone, compressionGzip, compressionZstd,
+		compressionBzip2, compressionXz, compressionSnappy, compressionZip:
+		return true
+	}
+	return false
+}
+
+// detectCompression detects a compression format by magic bytes.
+func detectCompression(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, gzipMagicBytes):
+		return compressionGzip
+	case bytes.HasPrefix(b, zstdMagicBytes):
+		return compressionZstd
+	case bytes.HasPrefix(b, bzip2MagicBytes):
+		return compressionBzip2
+	case bytes.HasPrefix(b, xzMagicBytes):
+		return compressionXz
+	case bytes.HasPrefix(b, snappyMagicBytes):
+		return compressionSnappy
+	case bytes.HasPrefix(b, zipMagicBytes), bytes.HasPrefix(b, zipEmptyBytes):
+		return compressionZip
+	}
+	return compressionNone
+}
+
+// decompress returns entries of the src decompressed by the compression.
+// When compression is empty or "auto", it is detected by magic bytes.
+func decompress(src io.Reader, compression string) ([]sourceEntry, error) {
+	bufSrc := bufio.NewReader(src)
+	if compression == "" || compression == compressionAuto {
+		// less than len(snappyMagicBytes) bytes will be detected as none
+		b, _ := bufSrc.Peek(len(snappyMagicBytes))
+		compression = detectCompression(b)
+	}
+	var rc io.ReadCloser
+	switch compression {
+	case compressionNone:
+		rc = io.NopCloser(bufSrc)
+	case compressionGzip:
+		gr, err := gzip.NewReader(bufSrc)
+		if err != nil {
+			return nil, err
+		}
+		rc = gr
+	case compressionZstd:
+		zr, err := zstd.NewReader(bufSrc)
+		if err != nil {
+			return nil, err
+		}
+		rc = zr.IOReadCloser()
+	case compressionBzip2:
+		rc = io.NopCloser(bzip2.NewReader(bufSrc))
+	case compressionXz:
+		xr, err := xz.NewReader(bufSrc)
+		if err != nil {
+			return nil, err
+		}
+		rc = io.NopCloser(xr)
+	case compressionSnappy:
+		rc = io.NopCloser(snappy.NewReader(bufSrc))
+	case compressionZip:
+		return unZip(bufSrc)
+	default:
+		return nil, fmt.Errorf("unknown source compression %s", compression)
+	}
+	return []sourceEntry{
+		{open: func() (io.ReadCloser, error) { return rc, nil }},
+	}, nil
+}
+
+// unZip reads whole of src to memory because zip requires io.ReaderAt.
+func unZip(src io.Reader) ([]sourceEntry, error) {
+	b, err := io.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]sourceEntry, 0, len(zr.File))
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		entries = append(entries, sourceEntry{
+			name: f.Name,
+			open: f.Open,
+		})
+	}
+	return entries, nil
+}
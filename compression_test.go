@@ -0,0 +1,118 @@
+package router_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+const testDecompressContent = `{"tag":"a"}` + "\n"
+
+// bzip2 compressed testDecompressContent
+var testBzip2Content = []byte{
+	66, 90, 104, 57, 49, 65, 89, 38, 83, 89, 103, 170, 90, 134, 0, 0, 5, 89, 128, 0,
+	16, 16, 0, 0, 16, 32, 128, 4, 10, 32, 0, 34, 13, 25, 168, 67, 2, 7, 196, 148,
+	36, 103, 139, 185, 34, 156, 40, 72, 51, 213, 45, 67, 0,
+}
+
+func compressForTest(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, testDecompressContent)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	cases := map[string][]byte{
+		"none": []byte(testDecompressContent),
+		"gzip": compressForTest(t, func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}),
+		"zstd": compressForTest(t, func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}),
+		"xz": compressForTest(t, func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		}),
+		"snappy": compressForTest(t, func(w io.Writer) (io.WriteCloser, error) {
+			return snappy.NewBufferedWriter(w), nil
+		}),
+		"bzip2": testBzip2Content,
+	}
+	for name, src := range cases {
+		for _, compression := range []string{"auto", name} {
+			res, err := router.DoTestDecompress(bytes.NewReader(src), compression)
+			if err != nil {
+				t.Errorf("%s(%s): %s", name, compression, err)
+				continue
+			}
+			if d := cmp.Diff(map[string]string{"": testDecompressContent}, res); d != "" {
+				t.Errorf("%s(%s): unexpected decompressed data: %s", name, compression, d)
+			}
+		}
+	}
+}
+
+func TestDecompressZip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	expected := map[string]string{
+		"a.log":     testDecompressContent,
+		"dir/b.log": `{"tag":"b"}` + "\n",
+	}
+	for name, content := range expected {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	zw.Close()
+
+	res, err := router.DoTestDecompress(&buf, "auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := cmp.Diff(expected, res); d != "" {
+		t.Error("unexpected decompressed data:", d)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	for _, name := range []string{"none", "gzip", "zstd", "snappy", "bzip2"} {
+		for _, level := range []int{0, 1} {
+			if name == "snappy" && level != 0 {
+				continue
+			}
+			b, err := router.DoTestCompress(name, level, testDecompressContent)
+			if err != nil {
+				t.Errorf("%s(%d): %s", name, level, err)
+				continue
+			}
+			res, err := router.DoTestDecompress(bytes.NewReader(b), "auto")
+			if err != nil {
+				t.Errorf("%s(%d): %s", name, level, err)
+				continue
+			}
+			if d := cmp.Diff(map[string]string{"": testDecompressContent}, res); d != "" {
+				t.Errorf("%s(%d): unexpected compressed data: %s", name, level, d)
+			}
+		}
+	}
+}
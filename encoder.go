@@ -2,51 +2,109 @@ package router
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"sync"
 )
 
 // LF represents LineFeed \n
 var LF = []byte("\n")
 
-type encoder interface {
-	Encode(*record) error
-	Buffer() buffer
+// Content-Type of each object format
+const (
+	noneContentType = "text/plain"
+	jsonContentType = "application/x-ndjson"
+)
+
+// Encoder encodes records to the Buffer of a destination object.
+type Encoder interface {
+	Encode(*Record) error
+	Buffer() Buffer
+}
+
+// EncoderFactory creates an Encoder which writes to the Buffer for each destination object.
+type EncoderFactory func(Buffer) Encoder
+
+type objectFormat struct {
+	newEncoder  EncoderFactory
+	contentType string
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]objectFormat{}
+)
+
+// RegisterEncoder registers an encoder factory by the name for Option.ObjectFormat.
+// contentType is the default Content-Type of uncompressed destination objects.
+// An encoder of the same name is replaced.
+func RegisterEncoder(name, contentType string, factory EncoderFactory) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[name] = objectFormat{newEncoder: factory, contentType: contentType}
+}
+
+func lookupEncoder(name string) (objectFormat, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	f, ok := formats[name]
+	return f, ok
+}
+
+func encoderNames() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterEncoder("none", noneContentType, newNoneEncoder)
+	RegisterEncoder("json", jsonContentType, newJSONEncoder)
 }
 
 type noneEncoder struct {
-	body buffer
+	body Buffer
 }
 
-func newNoneEncoder(body buffer) encoder {
+func newNoneEncoder(body Buffer) Encoder {
 	return &noneEncoder{
 		body: body,
 	}
 }
 
-func (e *noneEncoder) Encode(rec *record) error {
-	if _, err := e.body.Write(rec.raw); err != nil {
+func (e *noneEncoder) Encode(rec *Record) error {
+	if rec.Raw == nil {
+		return errors.New("record has no original line for none format")
+	}
+	if _, err := e.body.Write(rec.Raw); err != nil {
 		return err
 	}
 	_, err := e.body.Write(LF)
 	return err
 }
 
-func (e *noneEncoder) Buffer() buffer {
+func (e *noneEncoder) Buffer() Buffer {
 	return e.body
 }
 
 type jsonEncoder struct {
-	body buffer
+	body Buffer
 }
 
-func newJSONEncoder(body buffer) encoder {
+func newJSONEncoder(body Buffer) Encoder {
 	return &jsonEncoder{
 		body: body,
 	}
 }
 
-func (e *jsonEncoder) Encode(rec *record) error {
-	bytes, err := json.Marshal(rec.parsed)
+func (e *jsonEncoder) Encode(rec *Record) error {
+	bytes, err := json.Marshal(rec.Parsed)
 	if err != nil {
 		return fmt.Errorf("json marshal: %w", err)
 	}
@@ -57,6 +115,6 @@ func (e *jsonEncoder) Encode(rec *record) error {
 	return err
 }
 
-func (e *jsonEncoder) Buffer() buffer {
+func (e *jsonEncoder) Buffer() Buffer {
 	return e.body
 }
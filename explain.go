@@ -0,0 +1,130 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// RecordExplanation represents how a record is routed.
+type RecordExplanation struct {
+	Entry       string                 `json:"entry,omitempty"`
+	Line        int                    `json:"line"`
+	Fields      map[string]interface{} `json:"fields,omitempty"`
+	Time        *time.Time             `json:"time,omitempty"`
+	KeyPrefix   string                 `json:"key_prefix,omitempty"`
+	Rules       []string               `json:"rules,omitempty"` // replacer rules applied by the replace function
+	Destination string                 `json:"destination,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// DestinationExplanation represents a summary of a destination.
+type DestinationExplanation struct {
+	Destination string
+	Records     int
+	Bytes       int
+}
+
+// Explanation represents route decisions of records in a source.
+type Explanation struct {
+	Records      []*RecordExplanation
+	Destinations []*DestinationExplanation
+}
+
+type replaceRuler interface {
+	Rule(string) (pattern, replacement string, ok bool)
+}
+
+// Explain explains route decisions of every record in the source object without put.
+func (r *Router) Explain(ctx context.Context, s3url string) (*Explanation, error) {
+	src, err := r.getObject(ctx, s3url)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	return r.ExplainReader(src, s3url)
+}
+
+// ExplainReader explains route decisions of every record read from src without put.
+// name is used as a key base of destinations. Records are routed by the same way as Run.
+func (r *Router) ExplainReader(src io.Reader, name string) (*Explanation, error) {
+	var rules []string
+	funcs := newTemplateFuncs(func(s string) string {
+		if ruler, ok := r.option.replacer.(replaceRuler); ok {
+			if pattern, replacement, ok := ruler.Rule(s); ok {
+				rules = append(rules, fmt.Sprintf("%s => %s", pattern, replacement))
+				return replacement
+			}
+		}
+		return r.option.replacer.Replace(s)
+	})
+	genKeyPrefix, err := newKeyPrefixRenderer(r.option.KeyPrefix, funcs)
+	if err != nil {
+		return nil, err
+	}
+	// the key prefix is rendered with the replace function which records applied rules
+	er := *r
+	er.genKeyPrefix = genKeyPrefix
+
+	entries, err := decompress(src, r.option.SourceCompression)
+	if err != nil {
+		return nil, err
+	}
+	keyBase := r.genKeyBase(name)
+	ex := &Explanation{}
+	dests := make(map[destination]*output)
+	for _, entry := range entries {
+		hook := func(dec *routeDecision) {
+			re := &RecordExplanation{
+				Entry:     entry.name,
+				Line:      dec.line,
+				KeyPrefix: dec.keyPrefix,
+				Rules:     rules,
+			}
+			rules = nil
+			if dec.record != nil {
+				re.Fields = dec.record.Parsed
+				if t, ok := dec.record.Parsed[r.option.TimeKey].(time.Time); ok && !t.IsZero() {
+					re.Time = &t
+				}
+			}
+			if dec.destination != nil {
+				re.Destination = dec.destination.String()
+			}
+			if dec.err != nil {
+				re.Error = dec.err.Error()
+			}
+			ex.Records = append(ex.Records, re)
+		}
+		if _, err := er.routeEntry(entry, keyBase, dests, routeMode{hook: hook}); err != nil {
+			for _, out := range dests {
+				if out.sorter != nil {
+					out.sorter.close()
+				}
+			}
+			return nil, err
+		}
+	}
+	// sorted records are split into parts as Run
+	dests, _, err = r.sortOutputs(dests)
+	if err != nil {
+		return nil, err
+	}
+	for d, out := range dests {
+		buf := out.enc.Buffer()
+		if c, isCloser := buf.(io.Closer); isCloser {
+			c.Close()
+		}
+		ex.Destinations = append(ex.Destinations, &DestinationExplanation{
+			Destination: d.String(),
+			Records:     out.records,
+			Bytes:       len(buf.Bytes()),
+		})
+	}
+	sort.Slice(ex.Destinations, func(i, j int) bool {
+		return ex.Destinations[i].Destination < ex.Destinations[j].Destination
+	})
+	return ex, nil
+}
@@ -0,0 +1,77 @@
+package router_test
+
+import (
+	"strings"
+	"testing"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestExplain(t *testing.T) {
+	opt := testRouterOption("dest")
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := strings.NewReader(`{"tag":"app.a","datetime":"2020-01-01T00:00:00Z"}
+broken
+{"tag":"db","datetime":"xx"}
+{"tag":"app.b","datetime":"2020-01-01T00:00:01Z"}
+`)
+	ex, err := r.ExplainReader(src, "x.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.Records) != 4 {
+		t.Fatalf("unexpected records %#v", ex.Records)
+	}
+	first := ex.Records[0]
+	if first.Destination != "s3://dest/foo/app/2020-01-01/x.log" || first.Time == nil || first.Error != "" {
+		t.Errorf("unexpected explanation %#v", first)
+	}
+	if len(first.Rules) != 1 || first.Rules[0] != "app.* => app" {
+		t.Errorf("unexpected rules %v", first.Rules)
+	}
+	for i, line := range []int{2, 3} {
+		if rec := ex.Records[i+1]; rec.Line != line || rec.Error == "" {
+			t.Errorf("line %d must have an error %#v", line, rec)
+		}
+	}
+	if len(ex.Destinations) != 2 {
+		t.Fatalf("unexpected destinations %#v", ex.Destinations)
+	}
+	if d := ex.Destinations[0]; d.Destination != "s3://dest/foo/app/2020-01-01/x.log" || d.Records != 2 || d.Bytes == 0 {
+		t.Errorf("unexpected destination %#v", d)
+	}
+}
+
+func TestExplainSplitObjects(t *testing.T) {
+	opt := testRouterOption("dest")
+	opt.MaxObjectRecords = 2
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := strings.NewReader(`{"tag":"app.a","datetime":"2020-01-01T00:00:00Z"}
+{"tag":"app.b","datetime":"2020-01-01T00:00:01Z"}
+{"tag":"app.c","datetime":"2020-01-01T00:00:02Z"}
+`)
+	ex, err := r.ExplainReader(src, "x.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// explained as same as Run
+	expected := []string{
+		"s3://dest/foo/app/2020-01-01/x.log",
+		"s3://dest/foo/app/2020-01-01/x.log",
+		"s3://dest/foo/app/2020-01-01/x.log-0001",
+	}
+	for i, rec := range ex.Records {
+		if rec.Destination != expected[i] {
+			t.Errorf("line %d: unexpected destination %s", rec.Line, rec.Destination)
+		}
+	}
+	if len(ex.Destinations) != 2 || ex.Destinations[1].Records != 1 {
+		t.Errorf("unexpected destinations %#v", ex.Destinations)
+	}
+}
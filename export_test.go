@@ -1,20 +1,69 @@
 package router
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 var (
 // NewXXX = newXXX
 )
 
-func DoTestRoute(r *Router, src io.Reader, s3url string) (map[string]string, error) {
-	key := r.genKeyBase(s3url)
-	dests, err := r.route(src, key)
+func DoTestDecompress(src io.Reader, compression string) (map[string]string, error) {
+	entries, err := decompress(src, compression)
 	if err != nil {
 		return nil, err
 	}
-	res := make(map[string]string, len(dests))
-	for dest, buffer := range dests {
-		res[dest.String()] = string(buffer.Bytes())
+	res := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		rc, err := entry.open()
+		if err != nil {
+			return nil, err
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		res[entry.name] = string(b)
 	}
 	return res, nil
 }
+
+func DoTestCompress(name string, level int, content string) ([]byte, error) {
+	buf, err := newCompressedBuffer(compressions[name], level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := buf.Write([]byte(content)); err != nil {
+		return nil, err
+	}
+	if c, isCloser := buf.(io.Closer); isCloser {
+		if err := c.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func DoTestAttributes(r *Router, parsed map[string]interface{}) (map[string]string, error) {
+	attrs, err := r.attributes.Render(&Record{Parsed: parsed})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"storage_class":  attrs.storageClass,
+		"sse":            attrs.sse,
+		"sse_kms_key_id": attrs.sseKMSKeyID,
+		"acl":            attrs.acl,
+		"tagging":        attrs.tagging,
+	}, nil
+}
+
+func DoTestServeMessage(ctx context.Context, r *Router, agg *Aggregator, body string, del func()) error {
+	return r.serveMessage(ctx, agg, body, del)
+}
+
+func DoTestCheckVisibilityTimeout(visibilityTimeout string, opt *ServeOption) error {
+	return checkVisibilityTimeout(visibilityTimeout, opt)
+}
@@ -0,0 +1,205 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultGoldenSourceURL is a source URL of golden test cases which do not specify source_url.
+var DefaultGoldenSourceURL = "s3://example-bucket/path/to/example-object"
+
+const goldenBoundary = "----s3-object-router-test----"
+
+// GoldenCase represents config.json of a golden test case.
+// Sources are paths relative to the parent directory of the case directory.
+type GoldenCase struct {
+	Option
+	Sources        []string `json:"sources"`
+	EnableGzipTest bool     `json:"enable_gzip_test"`
+	SourceURL      string   `json:"source_url,omitempty"`
+}
+
+// GoldenResult represents a result of a source in a golden test case.
+// Routed and Expected are data of each destination URL.
+type GoldenResult struct {
+	Source     string
+	GoldenFile string
+	Routed     map[string]string
+	Expected   map[string]string // nil when the golden file is updated
+	Updated    bool
+}
+
+// Equal reports whether routed data equals to the golden file.
+func (r *GoldenResult) Equal() bool {
+	return r.Updated || maps.Equal(r.Expected, r.Routed)
+}
+
+// RunGoldenCase routes sources of the golden test case in dir without put,
+// and reads expected data from <source>.golden files in dir.
+// When update is true, golden files which differ from routed data are rewritten.
+func RunGoldenCase(dir string, update bool) ([]*GoldenResult, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	var c GoldenCase
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid config.json of %s: %w", dir, err)
+	}
+	if c.SourceURL == "" {
+		c.SourceURL = DefaultGoldenSourceURL
+	}
+	r, err := New(&c.Option)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string][]byte, len(c.Sources))
+	for _, src := range c.Sources {
+		raw, err := os.ReadFile(filepath.Join(filepath.Dir(filepath.Clean(dir)), src))
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(src)
+		sources[name] = raw
+		if c.EnableGzipTest {
+			var gzipped bytes.Buffer
+			gw := gzip.NewWriter(&gzipped)
+			gw.Write(raw)
+			gw.Close()
+			sources[name+"_gzipped"] = gzipped.Bytes()
+		}
+	}
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]*GoldenResult, 0, len(names))
+	for _, name := range names {
+		routed, err := r.routeData(bytes.NewReader(sources[name]), c.SourceURL)
+		if err != nil {
+			return results, fmt.Errorf("failed to route %s: %w", name, err)
+		}
+		res := &GoldenResult{
+			Source:     name,
+			GoldenFile: filepath.Join(dir, name+".golden"),
+			Routed:     routed,
+		}
+		results = append(results, res)
+		expected, err := readGoldenFile(res.GoldenFile)
+		if err != nil && !(update && errors.Is(err, fs.ErrNotExist)) {
+			return results, err
+		}
+		res.Expected = expected
+		if update && !res.Equal() {
+			// unchanged golden files are kept as is
+			if err := writeGoldenFile(res.GoldenFile, routed); err != nil {
+				return results, err
+			}
+			res.Expected, res.Updated = nil, true
+		}
+	}
+	return results, nil
+}
+
+// routeData routes records in src without put, and returns routed data of each destination.
+// Compressed bodies are closed and decompressed, so golden files are readable and the whole
+// stream is verified.
+func (r *Router) routeData(src io.Reader, s3url string) (map[string]string, error) {
+	dests, _, err := r.route(src, r.genKeyBase(s3url))
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(dests))
+	for dest, out := range dests {
+		buf := out.enc.Buffer()
+		if c, isCloser := buf.(io.Closer); isCloser {
+			c.Close()
+		}
+		entries, err := decompress(bytes.NewReader(buf.Bytes()), r.option.compression.name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dest.String(), err)
+		}
+		var b bytes.Buffer
+		for _, entry := range entries {
+			rc, err := entry.open()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", dest.String(), err)
+			}
+			_, err = io.Copy(&b, rc)
+			rc.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", dest.String(), err)
+			}
+		}
+		res[dest.String()] = b.String()
+	}
+	return res, nil
+}
+
+// WriteGolden writes routed data of each destination as a golden file (multipart format).
+func WriteGolden(w io.Writer, routed map[string]string) error {
+	dests := make([]string, 0, len(routed))
+	for dest := range routed {
+		dests = append(dests, dest)
+	}
+	sort.Strings(dests)
+	mw := multipart.NewWriter(w)
+	mw.SetBoundary(goldenBoundary)
+	for _, dest := range dests {
+		if err := mw.WriteField(dest, routed[dest]); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// ReadGolden reads routed data of each destination from a golden file.
+func ReadGolden(r io.Reader) (map[string]string, error) {
+	routed := map[string]string{}
+	mr := multipart.NewReader(r, goldenBoundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return routed, nil
+		} else if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		routed[part.FormName()] = string(content)
+	}
+}
+
+func writeGoldenFile(name string, routed map[string]string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return WriteGolden(f, routed)
+}
+
+func readGoldenFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadGolden(f)
+}
@@ -0,0 +1,162 @@
+package router
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// inventoryManifest represents manifest.json of S3 Inventory.
+type inventoryManifest struct {
+	SourceBucket      string          `json:"sourceBucket"`
+	DestinationBucket string          `json:"destinationBucket"`
+	FileFormat        string          `json:"fileFormat"`
+	FileSchema        string          `json:"fileSchema"`
+	Files             []inventoryFile `json:"files"`
+}
+
+// inventoryFile represents a data file in manifest.json of S3 Inventory.
+type inventoryFile struct {
+	Key string `json:"key"`
+}
+
+// columns returns indexes of Bucket, Key and LastModifiedDate columns in the file schema.
+func (m *inventoryManifest) columns() (bucket, key, lastModified int, err error) {
+	bucket, key, lastModified = -1, -1, -1
+	for i, name := range strings.Split(m.FileSchema, ",") {
+		switch strings.TrimSpace(name) {
+		case "Bucket":
+			bucket = i
+		case "Key":
+			key = i
+		case "LastModifiedDate":
+			lastModified = i
+		}
+	}
+	if bucket < 0 || key < 0 {
+		return 0, 0, 0, fmt.Errorf("fileSchema must have Bucket and Key: %s", m.FileSchema)
+	}
+	return bucket, key, lastModified, nil
+}
+
+// BackfillInventory routes every object listed in a S3 Inventory.
+// manifestURL is a URL of manifest.json of the inventory. Only CSV format is supported.
+// When the CheckpointFile is set, the key of each completed data file is saved and
+// a next BackfillInventory resumes from the next data file.
+func (r *Router) BackfillInventory(ctx context.Context, manifestURL string, opt *BackfillOption) error {
+	src, err := r.getObject(ctx, manifestURL)
+	if err != nil {
+		return err
+	}
+	var manifest inventoryManifest
+	err = json.NewDecoder(src).Decode(&manifest)
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode inventory manifest: %w", err)
+	}
+	if manifest.FileFormat != "CSV" {
+		return fmt.Errorf("inventory file format %s is not supported. CSV only", manifest.FileFormat)
+	}
+	bucketCol, keyCol, lastModifiedCol, err := manifest.columns()
+	if err != nil {
+		return err
+	}
+	scheme, _, _, err := parseObjectURL(manifestURL)
+	if err != nil {
+		return err
+	}
+	// destinationBucket is an ARN. e.g. arn:aws:s3:::bucket
+	inventoryBucket := manifest.DestinationBucket[strings.LastIndex(manifest.DestinationBucket, ":")+1:]
+
+	checkpoint, err := opt.readCheckpoint()
+	if err != nil {
+		return err
+	}
+	files := manifest.Files
+	if checkpoint != "" {
+		i := slices.IndexFunc(files, func(f inventoryFile) bool { return f.Key == checkpoint })
+		if i < 0 {
+			// a stale checkpoint, or a checkpoint of a backfill by a prefix
+			return fmt.Errorf("checkpoint %s is not found in the inventory manifest. remove the checkpoint file %s to start over", checkpoint, opt.CheckpointFile)
+		}
+		log.Println("[info] resume backfill after", checkpoint)
+		files = files[i+1:]
+	}
+	var processed, skipped int
+	for _, file := range files {
+		log.Println("[info] reading inventory", file.Key)
+		objs, err := r.readInventoryFile(ctx, objectURL(scheme, inventoryBucket, file.Key), bucketCol, keyCol, lastModifiedCol)
+		if err != nil {
+			return err
+		}
+		for bucket, bobjs := range objs {
+			p, s, err := r.backfillObjects(ctx, scheme, bucket, bobjs, opt)
+			processed, skipped = processed+p, skipped+s
+			if err != nil {
+				return err
+			}
+		}
+		if err := opt.writeCheckpoint(file.Key); err != nil {
+			return err
+		}
+	}
+	log.Printf("[info] backfill completed. %d objects processed, %d objects skipped", processed, skipped)
+	return opt.removeCheckpoint()
+}
+
+// readInventoryFile reads a CSV data file of S3 Inventory, and returns objects for each bucket.
+func (r *Router) readInventoryFile(ctx context.Context, fileURL string, bucketCol, keyCol, lastModifiedCol int) (map[string][]backfillObject, error) {
+	src, err := r.getObject(ctx, fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	entries, err := decompress(src, compressionAuto)
+	if err != nil {
+		return nil, err
+	}
+	objs := make(map[string][]backfillObject)
+	for _, entry := range entries {
+		rc, err := entry.open()
+		if err != nil {
+			return nil, err
+		}
+		err = func() error {
+			defer rc.Close()
+			cr := csv.NewReader(rc)
+			cr.FieldsPerRecord = -1
+			for {
+				row, err := cr.Read()
+				if err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if len(row) <= bucketCol || len(row) <= keyCol {
+					continue
+				}
+				// keys in inventory are URL encoded
+				key, err := url.QueryUnescape(row[keyCol])
+				if err != nil {
+					return err
+				}
+				obj := backfillObject{key: key}
+				if lastModifiedCol >= 0 && lastModifiedCol < len(row) {
+					obj.lastModified, _ = time.Parse(time.RFC3339, row[lastModifiedCol])
+				}
+				objs[row[bucketCol]] = append(objs[row[bucketCol]], obj)
+			}
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return objs, nil
+}
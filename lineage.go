@@ -0,0 +1,127 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Lineage represents where records of a source object were routed to.
+type Lineage struct {
+	Source       string               `json:"source"`
+	ETag         string               `json:"etag,omitempty"`
+	Size         int64                `json:"size"`
+	RoutedAt     time.Time            `json:"routed_at"`
+	Destinations []LineageDestination `json:"destinations"`
+}
+
+// LineageDestination represents a destination object in a Lineage.
+type LineageDestination struct {
+	URL     string     `json:"url"`
+	Records int        `json:"records"`
+	Bytes   int        `json:"bytes"`
+	MinTime *time.Time `json:"min_time,omitempty"`
+	MaxTime *time.Time `json:"max_time,omitempty"`
+	SHA256  string     `json:"sha256"`
+	Status  string     `json:"status"`
+	Error   string     `json:"error,omitempty"`
+}
+
+func newLineage(result *Result) *Lineage {
+	l := &Lineage{
+		Source:       result.Source,
+		ETag:         result.ETag,
+		Size:         result.Size,
+		RoutedAt:     time.Now().UTC(),
+		Destinations: make([]LineageDestination, 0, len(result.Outputs)),
+	}
+	for _, o := range result.Outputs {
+		d := LineageDestination{
+			URL:     o.Destination,
+			Records: o.Records,
+			Bytes:   o.Bytes,
+			SHA256:  o.Checksum,
+			Status:  o.Status,
+		}
+		if !o.MinTime.IsZero() {
+			minTime, maxTime := o.MinTime, o.MaxTime
+			d.MinTime, d.MaxTime = &minTime, &maxTime
+		}
+		if o.Err != nil {
+			d.Error = o.Err.Error()
+		}
+		l.Destinations = append(l.Destinations, d)
+	}
+	return l
+}
+
+// lineageKey returns a key of the lineage object of the source object.
+func (r *Router) lineageKey(s3url string) (string, error) {
+	_, bucket, key, err := parseObjectURL(s3url)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(r.option.lineagePrefix, bucket, key) + ".json", nil
+}
+
+// putLineage writes the lineage of the result to the destination storage.
+// The lineage object is put by the same write policy and retries as destination objects,
+// with the attributes of the sorted-first destination object (storage class, SSE, ACL and tags).
+func (r *Router) putLineage(ctx context.Context, result *Result, meta map[string]string) error {
+	if r.option.LineageTo == "" {
+		return nil
+	}
+	key, err := r.lineageKey(result.Source)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(newLineage(result), "", "  ")
+	if err != nil {
+		return err
+	}
+	dest := destination{
+		Scheme: r.option.destScheme,
+		Bucket: r.option.lineageBucket,
+		Key:    key,
+	}
+	log.Println("[info] put lineage", dest.String())
+	attrs := result.attrs
+	attrs.contentType = "application/json"
+	res := &OutputResult{Destination: dest.String()}
+	r.putObjectWithRetry(ctx, dest, bytes.NewReader(b), meta, attrs, res)
+	if res.Err != nil {
+		return fmt.Errorf("failed to put lineage of %s: %w", result.Source, res.Err)
+	}
+	return nil
+}
+
+// Trace returns the lineage of the source object written by a past Run.
+func (r *Router) Trace(ctx context.Context, s3url string) (*Lineage, error) {
+	if r.option.LineageTo == "" {
+		return nil, fmt.Errorf("lineage-to is not configured")
+	}
+	key, err := r.lineageKey(s3url)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.dest.Get(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.option.lineageBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lineage of %s: %w", s3url, err)
+	}
+	defer out.Body.Close()
+	var l Lineage
+	if err := json.NewDecoder(out.Body).Decode(&l); err != nil {
+		return nil, fmt.Errorf("failed to decode lineage of %s: %w", s3url, err)
+	}
+	return &l, nil
+}
@@ -0,0 +1,85 @@
+package router_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestLineage(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("src"),
+		Key:    aws.String("logs/x.log"),
+		Body: strings.NewReader(`{"tag":"app.a","datetime":"2020-01-01T00:00:00Z"}
+{"tag":"app.b","datetime":"2020-01-01T00:00:01Z"}
+{"tag":"app.c","datetime":"2020-01-01T00:00:02Z"}
+{"tag":"db","datetime":"2020-01-02T00:00:00Z"}
+`),
+	})
+	opt := testRouterOption("dest")
+	opt.LineageTo = "s3://dest/lineage/"
+	opt.Compression = "gzip"
+	opt.ContentEncoding = true
+	opt.Tags = `{"env":"test","tag":"{{ .tag }}"}`
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	if err := r.Run(ctx, "s3://src/logs/x.log"); err != nil {
+		t.Fatal(err)
+	}
+	lo := storage.Object("dest", "lineage/src/logs/x.log.json")
+	if lo == nil {
+		t.Fatal("lineage must be written")
+	}
+	// written as is with attributes of destination objects
+	if lo.ContentType != "application/json" || lo.ContentEncoding != "" {
+		t.Errorf("unexpected headers of lineage %s %s", lo.ContentType, lo.ContentEncoding)
+	}
+	// tags of the sorted-first destination foo/app/...
+	tags := make(map[string]string)
+	for _, tag := range lo.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	if len(tags) != 2 || tags["env"] != "test" || tags["tag"] != "app.a" {
+		t.Errorf("unexpected tags of lineage %v", tags)
+	}
+	l, err := r.Trace(ctx, "s3://src/logs/x.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Source != "s3://src/logs/x.log" || l.ETag == "" || l.Size == 0 {
+		t.Errorf("unexpected lineage %#v", l)
+	}
+	if len(l.Destinations) != 2 {
+		t.Fatalf("unexpected destinations %#v", l.Destinations)
+	}
+	for _, d := range l.Destinations {
+		if d.Status != router.OutputPut || d.SHA256 == "" || d.MinTime == nil {
+			t.Errorf("unexpected destination %#v", d)
+		}
+		if !strings.Contains(d.URL, "/app/") {
+			continue
+		}
+		if d.Records != 3 {
+			t.Errorf("unexpected records %d", d.Records)
+		}
+		if !d.MinTime.Equal(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)) || !d.MaxTime.Equal(time.Date(2020, 1, 1, 0, 0, 2, 0, time.UTC)) {
+			t.Errorf("unexpected time range %s - %s", d.MinTime, d.MaxTime)
+		}
+	}
+	// lineage objects are guarded from routing
+	if err := r.Run(ctx, "s3://dest/lineage/src/logs/x.log.json"); err == nil {
+		t.Error("lineage must not be routed")
+	}
+}
@@ -0,0 +1,218 @@
+package router
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// output represents a routed destination object.
+type output struct {
+	enc       Encoder
+	sorter    *recordSorter // sorts records before encoding when sort-by-time is enabled
+	collected []*Record     // records routed without encoding, to be merged into an aggregate
+	attrs     objectAttributes
+	part      int // the current part of the base destination split by the object limits
+	records   int
+	minTime   time.Time
+	maxTime   time.Time
+}
+
+// size returns the size of the (compressed) body, or records before encoding while sorting.
+func (o *output) size() int {
+	if o.sorter != nil {
+		return o.sorter.size()
+	}
+	return len(o.enc.Buffer().Bytes())
+}
+
+// count counts an encoded record and its time.
+func (o *output) count(rec *Record, timeKey string) {
+	o.records++
+	ts, ok := rec.Parsed[timeKey].(time.Time)
+	if !ok {
+		return
+	}
+	if o.minTime.IsZero() || ts.Before(o.minTime) {
+		o.minTime = ts
+	}
+	if o.maxTime.IsZero() || ts.After(o.maxTime) {
+		o.maxTime = ts
+	}
+}
+
+// objectAttributes represents attributes of a destination object rendered by a record.
+type objectAttributes struct {
+	storageClass string
+	sse          string
+	sseKMSKeyID  string
+	acl          string
+	tagging      string
+	contentType  string // overrides the content type of routed objects for objects written as is, such as lineage
+}
+
+func (a objectAttributes) apply(in *s3.PutObjectInput) {
+	if a.storageClass != "" {
+		in.StorageClass = types.StorageClass(a.storageClass)
+	}
+	if a.sse != "" {
+		in.ServerSideEncryption = types.ServerSideEncryption(a.sse)
+	}
+	if a.sseKMSKeyID != "" {
+		in.SSEKMSKeyId = aws.String(a.sseKMSKeyID)
+	}
+	if a.acl != "" {
+		in.ACL = types.ObjectCannedACL(a.acl)
+	}
+	if a.tagging != "" {
+		in.Tagging = aws.String(a.tagging)
+	}
+}
+
+type recordRenderer func(*Record) (string, error)
+
+var (
+	templateFuncsMu sync.RWMutex
+	templateFuncs   = template.FuncMap{}
+)
+
+// RegisterTemplateFunc registers a function for templates of key-prefix, object attributes and tags.
+// The name "replace" is reserved for the replace function of the replacer.
+func RegisterTemplateFunc(name string, fn interface{}) {
+	if reflect.TypeOf(fn).Kind() != reflect.Func {
+		panic("router.RegisterTemplateFunc: " + name + " is not a function")
+	}
+	templateFuncsMu.Lock()
+	defer templateFuncsMu.Unlock()
+	templateFuncs[name] = fn
+}
+
+// newTemplateFuncs returns registered functions and the replace function for templates.
+func newTemplateFuncs(replace func(string) string) template.FuncMap {
+	templateFuncsMu.RLock()
+	defer templateFuncsMu.RUnlock()
+	funcs := make(template.FuncMap, len(templateFuncs)+1)
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	funcs["replace"] = replace
+	return funcs
+}
+
+// newRecordRenderer creates a renderer of object attributes and tags by text/template,
+// so values of records are rendered without escaping.
+func newRecordRenderer(name, text string, funcs template.FuncMap) (recordRenderer, error) {
+	if text == "" {
+		return func(*Record) (string, error) { return "", nil }, nil
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return executeRenderer(tmpl), nil
+}
+
+// newKeyPrefixRenderer creates a renderer of the key prefix by html/template,
+// which escapes values as before for compatibility of key names.
+func newKeyPrefixRenderer(text string, funcs template.FuncMap) (recordRenderer, error) {
+	if text == "" {
+		return func(*Record) (string, error) { return "", nil }, nil
+	}
+	tmpl, err := htmltemplate.New("prefixGenerator").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return executeRenderer(tmpl), nil
+}
+
+func executeRenderer(tmpl interface {
+	Execute(io.Writer, interface{}) error
+}) recordRenderer {
+	return func(r *Record) (string, error) {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, r.Parsed); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+}
+
+type tagRenderer struct {
+	key    string
+	render recordRenderer
+}
+
+type attributesRenderer struct {
+	storageClass recordRenderer
+	sse          recordRenderer
+	sseKMSKeyID  recordRenderer
+	acl          recordRenderer
+	tags         []tagRenderer
+}
+
+func newAttributesRenderer(opt *Option, funcs template.FuncMap) (*attributesRenderer, error) {
+	var (
+		ar  attributesRenderer
+		err error
+	)
+	if ar.storageClass, err = newRecordRenderer("storageClass", opt.StorageClass, funcs); err != nil {
+		return nil, err
+	}
+	if ar.sse, err = newRecordRenderer("sse", opt.SSE, funcs); err != nil {
+		return nil, err
+	}
+	if ar.sseKMSKeyID, err = newRecordRenderer("sseKMSKeyID", opt.SSEKMSKeyID, funcs); err != nil {
+		return nil, err
+	}
+	if ar.acl, err = newRecordRenderer("acl", opt.ACL, funcs); err != nil {
+		return nil, err
+	}
+	for key, tmpl := range opt.tags {
+		render, err := newRecordRenderer("tag", tmpl, funcs)
+		if err != nil {
+			return nil, err
+		}
+		ar.tags = append(ar.tags, tagRenderer{key: key, render: render})
+	}
+	return &ar, nil
+}
+
+func (ar *attributesRenderer) Render(r *Record) (objectAttributes, error) {
+	var (
+		attrs objectAttributes
+		err   error
+	)
+	if attrs.storageClass, err = ar.storageClass(r); err != nil {
+		return attrs, err
+	}
+	if attrs.sse, err = ar.sse(r); err != nil {
+		return attrs, err
+	}
+	if attrs.sseKMSKeyID, err = ar.sseKMSKeyID(r); err != nil {
+		return attrs, err
+	}
+	if attrs.acl, err = ar.acl(r); err != nil {
+		return attrs, err
+	}
+	if len(ar.tags) > 0 {
+		values := url.Values{}
+		for _, tag := range ar.tags {
+			v, err := tag.render(r)
+			if err != nil {
+				return attrs, err
+			}
+			values.Set(tag.key, v)
+		}
+		attrs.tagging = values.Encode()
+	}
+	return attrs, nil
+}
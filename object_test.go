@@ -0,0 +1,128 @@
+package router_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestObjectAttributes(t *testing.T) {
+	r, err := router.New(&router.Option{
+		Bucket:       "dummy",
+		KeyPrefix:    "foo/{{ .tag }}/",
+		StorageClass: `{{ if eq .tag "old" }}STANDARD_IA{{ end }}`,
+		SSE:          "aws:kms",
+		SSEKMSKeyID:  "alias/{{ .tag }}",
+		ACL:          "bucket-owner-full-control",
+		Tags:         `{"app":"{{ .tag }}","routed":"true"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]map[string]string{
+		"old": {
+			"storage_class":  "STANDARD_IA",
+			"sse":            "aws:kms",
+			"sse_kms_key_id": "alias/old",
+			"acl":            "bucket-owner-full-control",
+			"tagging":        "app=old&routed=true",
+		},
+		"new": {
+			"storage_class":  "",
+			"sse":            "aws:kms",
+			"sse_kms_key_id": "alias/new",
+			"acl":            "bucket-owner-full-control",
+			"tagging":        "app=new&routed=true",
+		},
+		// values are not HTML-escaped
+		"a&b's": {
+			"storage_class":  "",
+			"sse":            "aws:kms",
+			"sse_kms_key_id": "alias/a&b's",
+			"acl":            "bucket-owner-full-control",
+			"tagging":        "app=a%26b%27s&routed=true",
+		},
+	}
+	for tag, expected := range cases {
+		attrs, err := router.DoTestAttributes(r, map[string]interface{}{"tag": tag})
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if d := cmp.Diff(expected, attrs); d != "" {
+			t.Errorf("%s: unexpected attributes: %s", tag, d)
+		}
+	}
+}
+
+func TestObjectHeaders(t *testing.T) {
+	cases := []struct {
+		name     string
+		setOpt   func(*router.Option)
+		key      string
+		expected router.MemoryObject
+	}{
+		{
+			name:     "gzip",
+			setOpt:   func(o *router.Option) { o.Gzip = true },
+			key:      "app/x.log.gz",
+			expected: router.MemoryObject{ContentType: "application/gzip"},
+		},
+		{
+			name: "content-encoding",
+			setOpt: func(o *router.Option) {
+				o.Compression = "gzip"
+				o.ContentEncoding = true
+				o.ObjectFormat = "json"
+			},
+			key:      "app/x.log",
+			expected: router.MemoryObject{ContentType: "application/x-ndjson", ContentEncoding: "gzip"},
+		},
+		{
+			name: "headers",
+			setOpt: func(o *router.Option) {
+				o.ContentType = "application/json"
+				o.CacheControl = "max-age=3600"
+				o.ContentDisposition = `attachment; filename="x.log"`
+			},
+			key: "app/x.log",
+			expected: router.MemoryObject{
+				ContentType:        "application/json",
+				CacheControl:       "max-age=3600",
+				ContentDisposition: `attachment; filename="x.log"`,
+			},
+		},
+	}
+	for _, tc := range cases {
+		ctx := context.Background()
+		storage := router.NewMemoryStorage()
+		opt := &router.Option{Bucket: "dest", KeyPrefix: "{{ .tag }}", PutS3: true}
+		tc.setOpt(opt)
+		r, err := router.New(opt)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		r.SetDestinationStorage(storage)
+		if err := r.RunReader(ctx, strings.NewReader(`{"tag":"app"}`+"\n"), "x.log"); err != nil {
+			t.Fatal(tc.name, err)
+		}
+		obj := storage.Object("dest", tc.key)
+		if obj == nil {
+			t.Errorf("%s: %s is not found", tc.name, tc.key)
+			continue
+		}
+		got := router.MemoryObject{
+			ContentType:        obj.ContentType,
+			ContentEncoding:    obj.ContentEncoding,
+			CacheControl:       obj.CacheControl,
+			ContentDisposition: obj.ContentDisposition,
+		}
+		if d := cmp.Diff(tc.expected, got); d != "" {
+			t.Errorf("%s: unexpected headers: %s", tc.name, d)
+		}
+	}
+}
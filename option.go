@@ -1,9 +1,9 @@
 package router
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 	_ "time/tzdata"
@@ -15,6 +15,16 @@ import (
 
 var DefaultTimeKey = "time"
 
+// write policies for existing destination objects
+const (
+	WritePolicyOverwrite    = "overwrite"
+	WritePolicySkipIfExists = "skip-if-exists"
+	WritePolicyFailIfExists = "fail-if-exists"
+)
+
+// DefaultPutRetryWait is a default base duration of backoff between retries of put.
+var DefaultPutRetryWait = time.Second
+
 // Option represents option values of router
 type Option struct {
 	Bucket           string `json:"bucket,omitempty"`
@@ -31,20 +41,74 @@ type Option struct {
 	ObjectFormat     string `json:"object_format,omitempty"`
 	KeepOriginalName bool   `json:"keep_original_name,omitempty"`
 
-	replacer     replacer
-	recordParser recordParser
-	newEncoder   func() encoder
-	newBuffer    func() buffer
-	timeParser   timeParser
+	MaxObjectBytes   int `json:"max_object_bytes,omitempty"`
+	MaxObjectRecords int `json:"max_object_records,omitempty"`
+
+	SortByTime       bool `json:"sort_by_time,omitempty"`
+	SortSpillRecords int  `json:"sort_spill_records,omitempty"`
+
+	SourceCompression string `json:"source_compression,omitempty"`
+	Compression       string `json:"compression,omitempty"`
+	CompressionLevel  int    `json:"compression_level,omitempty"`
+
+	ContentType        string `json:"content_type,omitempty"`
+	ContentEncoding    bool   `json:"content_encoding,omitempty"`
+	CacheControl       string `json:"cache_control,omitempty"`
+	ContentDisposition string `json:"content_disposition,omitempty"`
+
+	StorageClass        string `json:"storage_class,omitempty"`
+	SSE                 string `json:"sse,omitempty"`
+	SSEKMSKeyID         string `json:"sse_kms_key_id,omitempty"`
+	BucketKeyEnabled    bool   `json:"bucket_key_enabled,omitempty"`
+	ACL                 string `json:"acl,omitempty"`
+	ExpectedBucketOwner string `json:"expected_bucket_owner,omitempty"`
+	Tags                string `json:"tags,omitempty"`
+
+	Endpoint           string `json:"endpoint,omitempty"`
+	PathStyle          bool   `json:"path_style,omitempty"`
+	Region             string `json:"region,omitempty"`
+	Profile            string `json:"profile,omitempty"`
+	SourceRoleARN      string `json:"source_role_arn,omitempty"`
+	DestinationRoleARN string `json:"destination_role_arn,omitempty"`
+
+	PutRetries    int    `json:"put_retries,omitempty"`
+	PutRetryWait  string `json:"put_retry_wait,omitempty"`
+	SkipUnchanged bool   `json:"skip_unchanged,omitempty"`
+	WritePolicy   string `json:"write_policy,omitempty"`
+
+	SourceAction string `json:"source_action,omitempty"`
+	ArchiveTo    string `json:"archive_to,omitempty"`
+
+	MetaHeaderName    string `json:"meta_header_name,omitempty"`
+	MaxConcurrency    int    `json:"max_concurrency,omitempty"`
+	SourceConcurrency int    `json:"source_concurrency,omitempty"`
+	SourceTrigger     string `json:"source_trigger,omitempty"`
+
+	LineageTo string `json:"lineage_to,omitempty"`
+
+	replacer      replacer
+	newParser     ParserFactory
+	newEncoder    func() Encoder
+	newBuffer     func() Buffer
+	compression   compression
+	keySuffix     string
+	contentType   string
+	encoding      string
+	tags          map[string]string
+	destScheme    string
+	destBucket    string
+	putRetryWait  time.Duration
+	archiveBucket string
+	archivePrefix string
+	lineageBucket string
+	lineagePrefix string
+	timeParser    timeParser
 }
 
 type replacer interface {
 	Replace(string) string
 }
 
-type recordParser interface {
-	Parse([]byte) ([]*record, error)
-}
 type timeParser struct {
 	layout string
 	loc    *time.Location
@@ -63,6 +127,21 @@ func (opt *Option) Init() error {
 	if opt.Bucket == "" {
 		return errors.New("bucket must not be empty")
 	}
+	if strings.Contains(opt.Bucket, "://") {
+		scheme, bucket, key, err := parseObjectURL(opt.Bucket)
+		if err != nil {
+			return errors.Wrap(err, "invalid bucket")
+		}
+		opt.destScheme = scheme
+		if scheme == schemeFile {
+			opt.destBucket = key
+		} else {
+			opt.destBucket = bucket
+		}
+	} else {
+		opt.destScheme = schemeS3
+		opt.destBucket = opt.Bucket
+	}
 	if opt.KeyPrefix == "" {
 		return errors.New("key-prefix must not be empty")
 	}
@@ -88,34 +167,21 @@ func (opt *Option) Init() error {
 	} else {
 		opt.replacer = strings.NewReplacer() // nop replacer
 	}
-	switch opt.Parser {
-	case "", "json":
-		opt.recordParser = recordParserFunc(func(b []byte) ([]*record, error) {
-			r := newRecord(b)
-			if err := json.Unmarshal(b, &(r.parsed)); err != nil {
-				return nil, err
-			}
-			return []*record{r}, nil
-		})
-	case "json.Records":
-		opt.recordParser = recordParserFunc(func(b []byte) ([]*record, error) {
-			var m struct {
-				Records []map[string]interface{} `json:"Records"`
-			}
-			if err := json.Unmarshal(b, &m); err != nil {
-				return nil, err
-			}
-			records := make([]*record, 0, len(m.Records))
-			for _, parsed := range m.Records {
-				p := parsed
-				records = append(records, &record{parsed: p, raw: nil})
-			}
-			return records, nil
-		})
-	case "cloudfront":
-		opt.recordParser = &cloudfrontParser{}
-	default:
-		return errors.New("parser must be string any of json|cloudfront")
+	if opt.Tags != "" {
+		if err := json.Unmarshal([]byte(opt.Tags), &opt.tags); err != nil {
+			return errors.Wrap(err, "invalid tags")
+		}
+	}
+	if opt.Parser == "" {
+		opt.Parser = "json"
+	}
+	factory, ok := lookupParser(opt.Parser)
+	if !ok {
+		return errors.Errorf("parser must be string any of %s", strings.Join(parserNames(), "|"))
+	}
+	opt.newParser = factory
+	if !isSourceCompression(opt.SourceCompression) {
+		return errors.New("source-compression must be string any of auto|none|gzip|zstd|bzip2|xz|snappy|zip")
 	}
 	if opt.TimeParse {
 		p := timeParser{layout: opt.TimeFormat}
@@ -133,32 +199,202 @@ func (opt *Option) Init() error {
 		}
 		opt.timeParser = p
 	}
+	if opt.MaxObjectBytes < 0 {
+		return errors.New("max-object-bytes must not be negative")
+	}
+	if opt.MaxObjectRecords < 0 {
+		return errors.New("max-object-records must not be negative")
+	}
+	if opt.SortByTime && !opt.TimeParse {
+		return errors.New("sort-by-time requires time-parse")
+	}
+	if opt.SortSpillRecords == 0 {
+		opt.SortSpillRecords = DefaultSortSpillRecords
+	} else if opt.SortSpillRecords < 0 {
+		return errors.New("sort-spill-records must be positive")
+	}
+	if opt.PutRetries < 0 {
+		return errors.New("put-retries must not be negative")
+	}
+	if opt.PutRetryWait == "" {
+		opt.putRetryWait = DefaultPutRetryWait
+	} else {
+		d, err := time.ParseDuration(opt.PutRetryWait)
+		if err != nil {
+			return errors.Wrap(err, "invalid put-retry-wait")
+		}
+		opt.putRetryWait = d
+	}
+	switch opt.WritePolicy {
+	case "":
+		opt.WritePolicy = WritePolicyOverwrite
+	case WritePolicyOverwrite, WritePolicySkipIfExists, WritePolicyFailIfExists:
+	default:
+		return errors.New("write-policy must be string any of overwrite|skip-if-exists|fail-if-exists")
+	}
+	switch opt.SourceAction {
+	case "":
+		opt.SourceAction = SourceActionNone
+	case SourceActionNone, SourceActionDelete, SourceActionTag:
+	case SourceActionArchive:
+		if opt.ArchiveTo == "" {
+			return errors.New("archive-to must not be empty for source-action archive")
+		}
+	default:
+		return errors.New("source-action must be string any of none|delete|archive|tag")
+	}
+	if opt.ArchiveTo != "" {
+		scheme, bucket, key, err := parseObjectURL(opt.ArchiveTo)
+		if err != nil {
+			return errors.Wrap(err, "invalid archive-to")
+		}
+		if scheme == schemeFile {
+			opt.archivePrefix = key
+		} else {
+			opt.archiveBucket, opt.archivePrefix = bucket, key
+		}
+	}
+	if opt.LineageTo != "" {
+		scheme, bucket, key, err := parseObjectURL(opt.LineageTo)
+		if err != nil {
+			return errors.Wrap(err, "invalid lineage-to")
+		}
+		if scheme != opt.destScheme {
+			return errors.Errorf("lineage-to must be %s:// URL as same as bucket", opt.destScheme)
+		}
+		if scheme == schemeFile {
+			opt.lineagePrefix = key
+		} else {
+			opt.lineageBucket, opt.lineagePrefix = bucket, key
+		}
+	}
+	if opt.MetaHeaderName == "" {
+		opt.MetaHeaderName = MetaHeaderName
+	}
+	opt.MetaHeaderName = strings.ToLower(opt.MetaHeaderName)
+	if opt.MaxConcurrency == 0 {
+		opt.MaxConcurrency = MaxConcurrency
+	} else if opt.MaxConcurrency < 0 {
+		return errors.New("max-concurrency must be positive")
+	}
+	if opt.SourceConcurrency == 0 {
+		opt.SourceConcurrency = 1
+	} else if opt.SourceConcurrency < 0 {
+		return errors.New("source-concurrency must be positive")
+	}
+	if opt.SourceTrigger != "" {
+		if err := opt.checkLoop(); err != nil {
+			return err
+		}
+	}
 	if opt.TimeKey == "" {
 		opt.TimeKey = DefaultTimeKey
 	}
 
-	if opt.Gzip {
-		opt.newBuffer = newGzipBuffer
-	} else {
-		opt.newBuffer = func() buffer {
-			return new(bytes.Buffer)
+	if opt.Compression == "" {
+		// Gzip is kept for backward compatibility
+		if opt.Gzip {
+			opt.Compression = compressionGzip
+		} else {
+			opt.Compression = compressionNone
 		}
 	}
+	if c, ok := compressions[opt.Compression]; ok {
+		opt.compression = c
+	} else {
+		return errors.New("compression must be string any of none|gzip|zstd|snappy|bzip2")
+	}
+	buf, err := newCompressedBuffer(opt.compression, opt.CompressionLevel)
+	if err != nil {
+		return errors.Wrap(err, "invalid compression-level")
+	}
+	if c, isCloser := buf.(io.Closer); isCloser {
+		// release resources of the encoder created only for validation
+		c.Close()
+	}
+	opt.newBuffer = func() Buffer {
+		buf, _ := newCompressedBuffer(opt.compression, opt.CompressionLevel) // already validated
+		return buf
+	}
+
+	if opt.ObjectFormat == "" {
+		opt.ObjectFormat = "none"
+	}
+	format, ok := lookupEncoder(opt.ObjectFormat)
+	if !ok {
+		return errors.Errorf("format must be string any of %s", strings.Join(encoderNames(), "|"))
+	}
+	if opt.ObjectFormat == "none" && opt.Parser == "json.Records" {
+		return errors.New("parser must not be json.Records when object-format is none")
+	}
+	opt.newEncoder = func() Encoder {
+		return format.newEncoder(opt.newBuffer())
+	}
+	formatContentType := format.contentType
 
-	switch opt.ObjectFormat {
-	case "", "none":
-		if opt.Parser == "json.Records" {
-			return errors.New("parser must not be json.Records when object-format is none")
+	if opt.ContentEncoding && opt.compression.newWriter != nil {
+		// compressed body with Content-Encoding header and without key suffix
+		switch opt.compression.name {
+		case compressionGzip, compressionZstd:
+		default:
+			return errors.New("content-encoding supports only gzip|zstd compression")
 		}
-		opt.newEncoder = func() encoder {
-			return newNoneEncoder(opt.newBuffer())
+		opt.encoding = opt.compression.name
+		opt.contentType = formatContentType
+	} else {
+		opt.keySuffix = opt.compression.suffix
+		if opt.compression.contentType != "" {
+			opt.contentType = opt.compression.contentType
+		} else {
+			opt.contentType = formatContentType
 		}
-	case "json":
-		opt.newEncoder = func() encoder {
-			return newJSONEncoder(opt.newBuffer())
+	}
+	if opt.ContentType != "" {
+		opt.contentType = opt.ContentType
+	}
+	return nil
+}
+
+// checkLoop returns an error when destination objects or lineage objects can be put under the source trigger prefix.
+// The static part of key-prefix (before the first template action) is compared with the source trigger prefix.
+func (opt *Option) checkLoop() error {
+	scheme, bucket, triggerPrefix, err := parseObjectURL(opt.SourceTrigger)
+	if err != nil {
+		return errors.Wrap(err, "invalid source-trigger")
+	}
+	if scheme != opt.destScheme {
+		return nil
+	}
+	overlaps := func(destBucket, prefix string) bool {
+		prefix = strings.TrimPrefix(prefix, "/")
+		switch {
+		case scheme == schemeFile:
+			prefix = destBucket + "/" + prefix
+		case bucket != destBucket:
+			return false
 		}
-	default:
-		return errors.New("format must be string any of json|none")
+		return strings.HasPrefix(prefix, triggerPrefix) || strings.HasPrefix(triggerPrefix, prefix)
+	}
+	staticPrefix := opt.staticKeyPrefix()
+	if overlaps(opt.destBucket, staticPrefix) {
+		return fmt.Errorf(
+			"destination %s can overlap the source trigger %s. routed objects will trigger the router again",
+			objectURL(opt.destScheme, opt.destBucket, staticPrefix), opt.SourceTrigger,
+		)
+	}
+	if opt.LineageTo != "" && overlaps(opt.lineageBucket, opt.lineagePrefix) {
+		return fmt.Errorf(
+			"lineage-to %s can overlap the source trigger %s. lineage objects will trigger the router",
+			opt.LineageTo, opt.SourceTrigger,
+		)
 	}
 	return nil
 }
+
+// staticKeyPrefix returns the static part of key-prefix before the first template action.
+func (opt *Option) staticKeyPrefix() string {
+	if i := strings.Index(opt.KeyPrefix, "{{"); i >= 0 {
+		return opt.KeyPrefix[:i]
+	}
+	return opt.KeyPrefix
+}
@@ -0,0 +1,65 @@
+package router_test
+
+import (
+	"testing"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestSourceTrigger(t *testing.T) {
+	cases := []struct {
+		bucket    string
+		keyPrefix string
+		trigger   string
+		overlap   bool
+	}{
+		{"logs", "routed/{{ .tag }}", "s3://logs/raw/", false},
+		{"logs", "raw/{{ .tag }}", "s3://logs/raw/", true},
+		{"logs", "{{ .tag }}", "s3://logs/raw/", true},
+		{"logs", "r{{ .tag }}", "s3://logs/raw/", true},
+		{"routed", "raw/{{ .tag }}", "s3://logs/raw/", false},
+		{"file:///tmp/logs", "raw/{{ .tag }}", "file:///tmp/logs/raw/", true},
+		{"file:///tmp/logs", "routed/{{ .tag }}", "file:///tmp/logs/raw/", false},
+	}
+	for _, c := range cases {
+		_, err := router.New(&router.Option{
+			Bucket:        c.bucket,
+			KeyPrefix:     c.keyPrefix,
+			SourceTrigger: c.trigger,
+		})
+		if c.overlap && err == nil {
+			t.Errorf("%s/%s must overlap %s", c.bucket, c.keyPrefix, c.trigger)
+		} else if !c.overlap && err != nil {
+			t.Errorf("%s/%s must not overlap %s: %s", c.bucket, c.keyPrefix, c.trigger, err)
+		}
+	}
+}
+
+func TestSourceTriggerLineage(t *testing.T) {
+	cases := []struct {
+		bucket    string
+		lineageTo string
+		trigger   string
+		overlap   bool
+	}{
+		{"logs", "s3://logs/lineage/", "s3://logs/raw/", false},
+		{"logs", "s3://logs/raw/lineage/", "s3://logs/raw/", true},
+		{"logs", "s3://logs/", "s3://logs/raw/", true},
+		{"logs", "s3://raw/lineage/", "s3://logs/raw/", false},
+		{"file:///tmp/logs", "file:///tmp/logs/raw/lineage/", "file:///tmp/logs/raw/", true},
+		{"file:///tmp/logs", "file:///tmp/lineage/", "file:///tmp/logs/raw/", false},
+	}
+	for _, c := range cases {
+		_, err := router.New(&router.Option{
+			Bucket:        c.bucket,
+			KeyPrefix:     "routed/{{ .tag }}",
+			LineageTo:     c.lineageTo,
+			SourceTrigger: c.trigger,
+		})
+		if c.overlap && err == nil {
+			t.Errorf("%s must overlap %s", c.lineageTo, c.trigger)
+		} else if !c.overlap && err != nil {
+			t.Errorf("%s must not overlap %s: %s", c.lineageTo, c.trigger, err)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package router
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -16,10 +19,100 @@ var (
 	SkipLine = errors.New("Please skip this line.")
 )
 
-type recordParserFunc func([]byte) ([]*record, error)
+// Record represents a record in a source object.
+type Record struct {
+	Parsed map[string]interface{} // fields for templates and the json format
+	Raw    []byte                 // original bytes for the none format. nil when the record has no original line
+}
 
-func (p recordParserFunc) Parse(bs []byte) ([]*record, error) {
-	return p(bs)
+// NewRecord creates a Record of the raw bytes with empty fields.
+func NewRecord(raw []byte) *Record {
+	return &Record{
+		Parsed: make(map[string]interface{}),
+		Raw:    raw,
+	}
+}
+
+// Parser parses a line of a source object to records.
+// Parse returns SkipLine for lines which are not records, such as headers.
+type Parser interface {
+	Parse(line []byte) ([]*Record, error)
+}
+
+// ParserFactory creates a Parser for each source object.
+// Parsers can keep state of the object, such as fields in a header line.
+type ParserFactory func() Parser
+
+// ParserFunc is a stateless Parser.
+type ParserFunc func(line []byte) ([]*Record, error)
+
+// Parse parses a line.
+func (f ParserFunc) Parse(line []byte) ([]*Record, error) {
+	return f(line)
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = map[string]ParserFactory{}
+)
+
+// RegisterParser registers a parser factory by the name for Option.Parser.
+// A parser of the same name is replaced.
+func RegisterParser(name string, factory ParserFactory) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	parsers[name] = factory
+}
+
+func lookupParser(name string) (ParserFactory, bool) {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	factory, ok := parsers[name]
+	return factory, ok
+}
+
+func parserNames() []string {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterParser("json", stateless(ParserFunc(parseJSON)))
+	RegisterParser("json.Records", stateless(ParserFunc(parseJSONRecords)))
+	RegisterParser("cloudfront", func() Parser { return &cloudfrontParser{} })
+}
+
+// stateless returns a ParserFactory which returns the same parser for all objects.
+func stateless(p Parser) ParserFactory {
+	return func() Parser { return p }
+}
+
+func parseJSON(b []byte) ([]*Record, error) {
+	r := NewRecord(b)
+	if err := json.Unmarshal(b, &(r.Parsed)); err != nil {
+		return nil, err
+	}
+	return []*Record{r}, nil
+}
+
+func parseJSONRecords(b []byte) ([]*Record, error) {
+	var m struct {
+		Records []map[string]interface{} `json:"Records"`
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	records := make([]*Record, 0, len(m.Records))
+	for _, parsed := range m.Records {
+		records = append(records, &Record{Parsed: parsed})
+	}
+	return records, nil
 }
 
 type cloudfrontParser struct {
@@ -27,9 +120,12 @@ type cloudfrontParser struct {
 	fields  []string
 }
 
-func (p *cloudfrontParser) Parse(bs []byte) ([]*record, error) {
+func (p *cloudfrontParser) Parse(bs []byte) ([]*Record, error) {
 	str := string(bs)
-	rec := newRecord(bs)
+	if str == "" {
+		return nil, SkipLine
+	}
+	rec := NewRecord(bs)
 	if str[0] == '#' {
 		part := strings.SplitN(str[1:], ":", 2)
 		if len(part) != 2 {
@@ -58,13 +154,16 @@ func (p *cloudfrontParser) Parse(bs []byte) ([]*record, error) {
 		}
 		return nil, SkipLine
 	}
+	if len(p.fields) == 0 {
+		return nil, errors.New("no #Fields: header line before records")
+	}
 	values := strings.Split(str, "\t")
 	if len(values) > len(p.fields) {
 		return nil, fmt.Errorf("this row has more values ​​than fields, num of values = %d, num of feilds = %d", len(values), len(p.fields))
 	}
 	var dateValue, timeValue string
 	for i, field := range p.fields {
-		rec.parsed[field] = values[i]
+		rec.Parsed[field] = values[i]
 		if field == "date" {
 			dateValue = values[i]
 		}
@@ -72,6 +171,6 @@ func (p *cloudfrontParser) Parse(bs []byte) ([]*record, error) {
 			timeValue = values[i]
 		}
 	}
-	rec.parsed["datetime"] = dateValue + "T" + timeValue + "Z"
-	return []*record{rec}, nil
+	rec.Parsed["datetime"] = dateValue + "T" + timeValue + "Z"
+	return []*Record{rec}, nil
 }
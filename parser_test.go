@@ -0,0 +1,92 @@
+package router_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+// csvParser is a parser which has the state of the header line.
+type csvParser struct {
+	header []string
+}
+
+func (p *csvParser) Parse(line []byte) ([]*router.Record, error) {
+	values := strings.Split(string(line), ",")
+	if p.header == nil {
+		p.header = values
+		return nil, router.SkipLine
+	}
+	rec := router.NewRecord(line)
+	for i, name := range p.header {
+		if i < len(values) {
+			rec.Parsed[name] = values[i]
+		}
+	}
+	return []*router.Record{rec}, nil
+}
+
+func TestRegisterParser(t *testing.T) {
+	router.RegisterParser("test.csv", func() router.Parser { return &csvParser{} })
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	// header lines differ for each object
+	storage.Put(ctx, &s3.PutObjectInput{Bucket: aws.String("src"), Key: aws.String("a.csv"), Body: strings.NewReader("tag,message\napp,hello\n")})
+	storage.Put(ctx, &s3.PutObjectInput{Bucket: aws.String("src"), Key: aws.String("b.csv"), Body: strings.NewReader("message,tag\nworld,db\n")})
+	opt := &router.Option{
+		Bucket:           "dest",
+		KeyPrefix:        "{{ .tag }}",
+		Parser:           "test.csv",
+		PutS3:            true,
+		KeepOriginalName: true,
+	}
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	if err := r.RunAll(ctx, []string{"s3://src/a.csv", "s3://src/b.csv"}); err != nil {
+		t.Fatal(err)
+	}
+	if o := storage.Object("dest", "app/a.csv"); o == nil || !bytes.Equal(o.Body, []byte("app,hello\n")) {
+		t.Errorf("unexpected object %#v", o)
+	}
+	if o := storage.Object("dest", "db/b.csv"); o == nil || !bytes.Equal(o.Body, []byte("world,db\n")) {
+		t.Errorf("unexpected object %#v", o)
+	}
+
+	opt.Parser = "unknown"
+	if _, err := router.New(opt); err == nil || !strings.Contains(err.Error(), "test.csv") {
+		t.Errorf("unexpected error for unknown parser: %s", err)
+	}
+}
+
+func TestCloudFrontParserWithoutHeader(t *testing.T) {
+	r, err := router.New(&router.Option{Bucket: "dest", KeyPrefix: "{{ .x_edge_location }}", Parser: "cloudfront"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second object has no header, so it must not inherit fields of the first object
+	for i, src := range []string{
+		"#Version: 1.0\n#Fields: date time x-edge-location\n2019-12-04\t21:02:31\tLAX1\n",
+		"2019-12-04\t21:02:31\tLAX1\n",
+	} {
+		ex, err := r.ExplainReader(strings.NewReader(src), "x.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ex.Records) != 1 {
+			t.Fatalf("unexpected records %#v", ex.Records)
+		}
+		if hasError := ex.Records[0].Error != ""; hasError != (i == 1) {
+			t.Errorf("unexpected explanation of object %d: %#v", i, ex.Records[0])
+		}
+	}
+}
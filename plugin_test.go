@@ -0,0 +1,65 @@
+package router_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+// tsvEncoder writes tag and message fields separated by a tab.
+type tsvEncoder struct {
+	buf router.Buffer
+}
+
+func (e *tsvEncoder) Encode(rec *router.Record) error {
+	_, err := fmt.Fprintf(e.buf, "%v\t%v\n", rec.Parsed["tag"], rec.Parsed["message"])
+	return err
+}
+
+func (e *tsvEncoder) Buffer() router.Buffer {
+	return e.buf
+}
+
+func TestRegisterEncoderAndTemplateFunc(t *testing.T) {
+	router.RegisterEncoder("test.tsv", "text/tab-separated-values", func(buf router.Buffer) router.Encoder {
+		return &tsvEncoder{buf: buf}
+	})
+	router.RegisterTemplateFunc("upper", strings.ToUpper)
+
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("src"),
+		Key:    aws.String("x.log"),
+		Body:   strings.NewReader(`{"tag":"app","message":"hello"}` + "\n"),
+	})
+	opt := &router.Option{
+		Bucket:           "dest",
+		KeyPrefix:        "{{ upper .tag }}",
+		ObjectFormat:     "test.tsv",
+		PutS3:            true,
+		KeepOriginalName: true,
+	}
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	if err := r.Run(ctx, "s3://src/x.log"); err != nil {
+		t.Fatal(err)
+	}
+	o := storage.Object("dest", "APP/x.log")
+	if o == nil {
+		t.Fatal("APP/x.log is not routed")
+	}
+	if string(o.Body) != "app\thello\n" || o.ContentType != "text/tab-separated-values" {
+		t.Errorf("unexpected object %s %s", o.Body, o.ContentType)
+	}
+}
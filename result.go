@@ -0,0 +1,51 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// statuses of OutputResult
+const (
+	OutputPut     = "put"     // put to the destination
+	OutputSkipped = "skipped" // the destination already has the same content
+	OutputExists  = "exists"  // the destination already exists and write-policy is skip-if-exists
+	OutputFailed  = "failed"  // failed to put
+	OutputNotPut  = "not_put" // put is disabled by option
+)
+
+// Result represents a result of routing a source object.
+type Result struct {
+	Source       string
+	ETag         string
+	Size         int64
+	Outputs      []*OutputResult
+	RecordErrors int // number of records which are not routed or routed without valid time by errors
+
+	attrs objectAttributes // attributes of a destination object, applied to the lineage object
+}
+
+// OutputResult represents a result of a destination object.
+type OutputResult struct {
+	Destination string
+	Bytes       int
+	Records     int
+	MinTime     time.Time // zero when records have no parsed time
+	MaxTime     time.Time
+	Checksum    string // SHA256 of the body in hex
+	Status      string
+	Attempts    int
+	Err         error
+}
+
+// Err returns an error joined errors of failed outputs. It returns nil when all outputs succeeded.
+func (r *Result) Err() error {
+	var errs []error
+	for _, o := range r.Outputs {
+		if o.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.Destination, o.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
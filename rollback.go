@@ -0,0 +1,216 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/sync/errgroup"
+)
+
+// RollbackOption represents option values of Rollback
+type RollbackOption struct {
+	DryRun bool // only list objects to delete
+}
+
+// Rollback deletes destination objects routed from the source object or the source prefix.
+// srcURL which ends with "/" is treated as a prefix.
+// When lineage-to is configured, destinations are found by lineage objects (and they are deleted too).
+// Otherwise, destination objects under the static part of key-prefix are scanned by HeadObject
+// and objects which have the metadata of the original source URL are found.
+// It returns URLs of deleted (or to be deleted with DryRun) objects.
+func (r *Router) Rollback(ctx context.Context, srcURL string, opt *RollbackOption) ([]string, error) {
+	var (
+		targets []destination
+		err     error
+	)
+	if r.option.LineageTo != "" {
+		targets, err = r.rollbackTargetsByLineage(ctx, srcURL)
+	} else {
+		targets, err = r.rollbackTargetsByMetadata(ctx, srcURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, 0, len(targets))
+	for _, t := range targets {
+		urls = append(urls, t.String())
+		if opt.DryRun {
+			log.Println("[info] [dry-run] delete", t.String())
+			continue
+		}
+		log.Println("[info] delete", t.String())
+		_, err := r.dest.Delete(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(t.Bucket),
+			Key:    aws.String(t.Key),
+		})
+		if err != nil {
+			return urls, fmt.Errorf("failed to delete %s: %w", t.String(), err)
+		}
+	}
+	return urls, nil
+}
+
+// matchSource reports whether the original source URL is the source object or under the source prefix.
+func matchSource(srcURL, original string) bool {
+	if strings.HasSuffix(srcURL, "/") {
+		return strings.HasPrefix(original, srcURL)
+	}
+	return original == srcURL
+}
+
+// rollbackTargetsByLineage returns destinations in lineage objects of the source, and lineage objects themselves.
+func (r *Router) rollbackTargetsByLineage(ctx context.Context, srcURL string) ([]destination, error) {
+	key, err := r.lineageKey(srcURL)
+	if err != nil {
+		return nil, err
+	}
+	var lineageKeys []string
+	if strings.HasSuffix(srcURL, "/") {
+		// lineageKey appends .json to the prefix
+		prefix := strings.TrimSuffix(key, ".json") + "/"
+		lineageKeys, err = r.listDestination(ctx, r.option.lineageBucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		lineageKeys = []string{key}
+	}
+
+	var targets []destination
+	for _, lk := range lineageKeys {
+		lineage, err := r.getLineage(ctx, lk)
+		if err != nil {
+			return nil, err
+		}
+		if !matchSource(srcURL, lineage.Source) {
+			continue
+		}
+		for _, d := range lineage.Destinations {
+			if d.Status == OutputFailed || d.Status == OutputNotPut {
+				continue
+			}
+			_, bucket, key, err := parseObjectURL(d.URL)
+			if err != nil {
+				return nil, err
+			}
+			dest := destination{Scheme: r.option.destScheme, Bucket: bucket, Key: key}
+			ok, err := r.routedFromSource(ctx, dest, lineage.Source)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				targets = append(targets, dest)
+			}
+		}
+		targets = append(targets, destination{Scheme: r.option.destScheme, Bucket: r.option.lineageBucket, Key: lk})
+	}
+	return targets, nil
+}
+
+func (r *Router) getLineage(ctx context.Context, key string) (*Lineage, error) {
+	out, err := r.dest.Get(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.option.lineageBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lineage %s: %w", key, err)
+	}
+	defer out.Body.Close()
+	var l Lineage
+	if err := json.NewDecoder(out.Body).Decode(&l); err != nil {
+		return nil, fmt.Errorf("failed to decode lineage %s: %w", key, err)
+	}
+	return &l, nil
+}
+
+// routedFromSource checks the destination object still exists and was routed from the source.
+// Objects written later by another source with the same key are kept.
+// Objects without metadata (e.g. on local filesystem) are trusted.
+func (r *Router) routedFromSource(ctx context.Context, dest destination, srcURL string) (bool, error) {
+	out, err := r.dest.Head(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(dest.Bucket),
+		Key:    aws.String(dest.Key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	original, ok := r.routedFrom(out.Metadata)
+	if ok && original != srcURL {
+		log.Printf("[warn] %s was overwritten by %s. skipped", dest.String(), original)
+		return false, nil
+	}
+	return true, nil
+}
+
+// rollbackTargetsByMetadata scans destination objects by HeadObject and returns objects routed from the source.
+func (r *Router) rollbackTargetsByMetadata(ctx context.Context, srcURL string) ([]destination, error) {
+	prefix := strings.TrimPrefix(r.option.staticKeyPrefix(), "/")
+	keys, err := r.listDestination(ctx, r.option.destBucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[info] scanning %d objects under %s", len(keys), objectURL(r.option.destScheme, r.option.destBucket, prefix))
+	var (
+		mu      sync.Mutex
+		targets []destination
+	)
+	eg := errgroup.Group{}
+	eg.SetLimit(r.option.MaxConcurrency)
+	for _, key := range keys {
+		eg.Go(func() error {
+			out, err := r.dest.Head(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(r.option.destBucket),
+				Key:    aws.String(key),
+			})
+			if err != nil {
+				return err
+			}
+			if original, ok := r.routedFrom(out.Metadata); ok && matchSource(srcURL, original) {
+				mu.Lock()
+				targets = append(targets, destination{Scheme: r.option.destScheme, Bucket: r.option.destBucket, Key: key})
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Key < targets[j].Key })
+	return targets, nil
+}
+
+// listDestination lists all keys under the prefix in the destination storage.
+func (r *Router) listDestination(ctx context.Context, bucket, prefix string) ([]string, error) {
+	in := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	var keys []string
+	for {
+		out, err := r.dest.List(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		for _, content := range out.Contents {
+			keys = append(keys, aws.ToString(content.Key))
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return keys, nil
+		}
+		in.ContinuationToken = out.NextContinuationToken
+	}
+}
@@ -0,0 +1,72 @@
+package router_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func TestRollback(t *testing.T) {
+	for _, lineageTo := range []string{"", "s3://dest/lineage/"} {
+		ctx := context.Background()
+		storage := router.NewMemoryStorage()
+		for _, key := range []string{"logs/x.log", "logs/y.log", "other/z.log"} {
+			storage.Put(ctx, &s3.PutObjectInput{
+				Bucket: aws.String("src"),
+				Key:    aws.String(key),
+				Body: strings.NewReader(`{"tag":"app.a","datetime":"2020-01-01T00:00:00Z"}
+{"tag":"db","datetime":"2020-01-02T00:00:00Z"}
+`),
+			})
+		}
+		opt := testRouterOption("dest")
+		opt.LineageTo = lineageTo
+		r, err := router.New(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetSourceStorage("s3", storage)
+		r.SetDestinationStorage(storage)
+		for _, key := range []string{"logs/x.log", "logs/y.log", "other/z.log"} {
+			if err := r.Run(ctx, "s3://src/"+key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		urls, err := r.Rollback(ctx, "s3://src/logs/x.log", &router.RollbackOption{DryRun: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := 2
+		if lineageTo != "" {
+			expected = 3 // with the lineage object
+		}
+		if len(urls) != expected {
+			t.Errorf("lineage-to=%s: unexpected urls %v", lineageTo, urls)
+		}
+		if storage.Object("dest", "foo/app/2020-01-01/x.log") == nil {
+			t.Errorf("lineage-to=%s: dry-run must not delete objects", lineageTo)
+		}
+
+		urls, err = r.Rollback(ctx, "s3://src/logs/", &router.RollbackOption{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(urls) != expected*2 {
+			t.Errorf("lineage-to=%s: unexpected urls %v", lineageTo, urls)
+		}
+		for _, name := range []string{"x.log", "y.log"} {
+			if storage.Object("dest", "foo/app/2020-01-01/"+name) != nil {
+				t.Errorf("lineage-to=%s: %s must be deleted", lineageTo, name)
+			}
+		}
+		if storage.Object("dest", "foo/app/2020-01-01/z.log") == nil {
+			t.Errorf("lineage-to=%s: outputs of other sources must be kept", lineageTo)
+		}
+	}
+}
@@ -3,34 +3,42 @@ package router
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/md5"
 	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"log"
+	"math/rand"
 	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 )
 
-// MaxConcurrency represents maximum concurrency for uploading to S3
+// MaxConcurrency represents default maximum concurrency for uploading to S3
 var MaxConcurrency = 10
 
-// MetaHeaderName is metadata name to set routed objects.
+// maxRetryWait is the upper limit of backoff between retries.
+var maxRetryWait = 30 * time.Second
+
+// MetaHeaderName is default metadata name to set routed objects.
 var MetaHeaderName = "x-amz-meta-route-original"
 
-var gzipMagicBytes = []byte{0x1f, 0x8b}
+// ErrDestinationExists represents a destination object already exists and write-policy is fail-if-exists.
+var ErrDestinationExists = errors.New("destination object already exists")
 
-var gzipSuffix = ".gz"
+// ErrRoutedObject represents a source object is an already routed object.
+var ErrRoutedObject = errors.New("seems to be an already routed object")
 
 var (
 	initialBufSize = 64 * 1024
@@ -39,11 +47,13 @@ var (
 
 // Router represents s3-object-router application
 type Router struct {
-	s3     *s3.Client
-	option *Option
-	sem    *semaphore.Weighted
+	sources map[string]Storage
+	dest    Storage
+	option  *Option
+	sem     *semaphore.Weighted
 
-	genKeyPrefix func(*record) (string, error)
+	genKeyPrefix recordRenderer
+	attributes   *attributesRenderer
 }
 
 // New creates a new router
@@ -52,236 +62,691 @@ func New(opt *Option) (*Router, error) {
 		return nil, err
 	}
 
-	awsConf, err := config.LoadDefaultConfig(context.TODO())
+	awsConf, err := loadAWSConfig(context.TODO(), opt)
 	if err != nil {
 		return nil, err
 	}
 
-	tmpl := template.New("prefixGenerator")
-	tmpl.Funcs(template.FuncMap{
-		"replace": opt.replacer.Replace,
-	})
-	if tmpl, err = tmpl.Parse(opt.KeyPrefix); err != nil {
+	funcs := newTemplateFuncs(opt.replacer.Replace)
+	genKeyPrefix, err := newKeyPrefixRenderer(opt.KeyPrefix, funcs)
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := newAttributesRenderer(opt, funcs)
+	if err != nil {
 		return nil, err
 	}
 
+	var dest Storage
+	switch opt.destScheme {
+	case schemeFile:
+		dest = NewLocalStorage()
+	default:
+		dest = NewS3Storage(newS3Client(awsConf, opt, opt.DestinationRoleARN))
+	}
+
 	return &Router{
-		s3:     s3.NewFromConfig(awsConf),
-		option: opt,
-		sem:    semaphore.NewWeighted(int64(MaxConcurrency)),
-		genKeyPrefix: func(r *record) (string, error) {
-			var b strings.Builder
-			if err := tmpl.Execute(&b, r.parsed); err != nil {
-				return "", err
-			}
-			return b.String(), nil
+		sources: map[string]Storage{
+			schemeS3:   NewS3Storage(newS3Client(awsConf, opt, opt.SourceRoleARN)),
+			schemeFile: NewLocalStorage(),
 		},
+		dest:         dest,
+		option:       opt,
+		sem:          semaphore.NewWeighted(int64(opt.MaxConcurrency)),
+		genKeyPrefix: genKeyPrefix,
+		attributes:   attributes,
 	}, nil
 }
 
+// SetSourceStorage sets a storage to read source objects which have the URL scheme.
+func (r *Router) SetSourceStorage(scheme string, s Storage) {
+	r.sources[scheme] = s
+}
+
+// SetDestinationStorage sets a storage to write destination objects.
+func (r *Router) SetDestinationStorage(s Storage) {
+	r.dest = s
+}
+
 // Run runs router
 func (r *Router) Run(ctx context.Context, s3url string) error {
+	_, err := r.RunWithResult(ctx, s3url)
+	return err
+}
+
+// RunAll runs router for the source objects. Up to SourceConcurrency objects are routed concurrently,
+// and puts of all objects share the MaxConcurrency limit. Memory usage is bounded by
+// SourceConcurrency because routed data of each object is buffered until it is put.
+// A failure of an object does not stop the others, and the error joins errors of all failed objects.
+func (r *Router) RunAll(ctx context.Context, s3urls []string) error {
+	var eg errgroup.Group
+	eg.SetLimit(r.option.SourceConcurrency)
+	var mu sync.Mutex
+	var errs []error
+	for _, s3url := range s3urls {
+		if ctx.Err() != nil {
+			break
+		}
+		eg.Go(func() error {
+			if err := r.Run(ctx, s3url); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", s3url, err))
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	eg.Wait()
+	if err := ctx.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// RunWithResult runs router and returns the result of each destination.
+func (r *Router) RunWithResult(ctx context.Context, s3url string) (*Result, error) {
 	log.Println("[info] run", s3url)
-	src, err := r.getS3Object(ctx, s3url)
+	head, err := r.loopGuard(ctx, s3url)
+	if err != nil {
+		return nil, err
+	}
+	src, err := r.getObject(ctx, s3url)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer src.Close()
 	keyBase := r.genKeyBase(s3url)
 	meta := map[string]string{
-		MetaHeaderName: s3url,
+		r.option.MetaHeaderName: s3url,
+	}
+	result, err := r.Route(ctx, src, keyBase, meta)
+	if result != nil {
+		result.Source = s3url
+		result.ETag = aws.ToString(head.ETag)
+		result.Size = aws.ToInt64(head.ContentLength)
+	}
+	if err != nil {
+		if result != nil && r.option.PutS3 {
+			// lineage of succeeded destinations helps to rollback
+			if err := r.putLineage(ctx, result, meta); err != nil {
+				log.Println("[warn]", err)
+			}
+		}
+		return result, err
+	}
+	if !r.option.PutS3 {
+		return result, nil
 	}
-	return r.Route(ctx, src, keyBase, meta)
+	if err := r.putLineage(ctx, result, meta); err != nil {
+		return result, err
+	}
+	if r.keepSource(s3url, len(result.Outputs), result.RecordErrors) {
+		return result, nil
+	}
+	return result, r.doSourceAction(ctx, s3url)
 }
 
-func (r *Router) Route(ctx context.Context, src io.Reader, keyBase string, meta map[string]string) error {
-	dests, err := r.route(src, keyBase)
+// RunReader runs router for src read from a reader such as stdin.
+// name is used as a key base and an original name in metadata.
+func (r *Router) RunReader(ctx context.Context, src io.Reader, name string) error {
+	log.Println("[info] run", name)
+	keyBase := r.genKeyBase(name)
+	meta := map[string]string{
+		r.option.MetaHeaderName: name,
+	}
+	_, err := r.Route(ctx, src, keyBase, meta)
+	return err
+}
+
+// Route routes records in src to destinations. Puts to all destinations are tried
+// even if some of them failed, and the error reports all failed destinations.
+func (r *Router) Route(ctx context.Context, src io.Reader, keyBase string, meta map[string]string) (*Result, error) {
+	dests, recordErrors, err := r.route(src, keyBase)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	result := &Result{
+		Outputs:      make([]*OutputResult, 0, len(dests)),
+		RecordErrors: recordErrors,
+	}
 	eg := errgroup.Group{}
-	for dest, buf := range dests {
-		dest, buf := dest, buf
+	var first string
+	for dest, out := range dests {
+		dest, out := dest, out
+		buf := out.enc.Buffer()
 		if c, isCloser := buf.(io.Closer); isCloser {
 			c.Close()
 		}
 		body := bytes.NewReader(buf.Bytes())
 		log.Println("[info] route", dest.String(), body.Len(), "bytes")
+		res := &OutputResult{
+			Destination: dest.String(),
+			Bytes:       body.Len(),
+			Records:     out.records,
+			MinTime:     out.minTime,
+			MaxTime:     out.maxTime,
+			Checksum:    fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())),
+			Status:      OutputNotPut,
+		}
+		result.Outputs = append(result.Outputs, res)
+		if first == "" || res.Destination < first {
+			// the lineage object is put with attributes of the sorted-first destination
+			first, result.attrs = res.Destination, out.attrs
+		}
 		if r.option.PutS3 {
 			eg.Go(func() error {
-				return r.putToS3(ctx, dest, body, meta)
+				r.putObjectWithRetry(ctx, dest, body, meta, out.attrs, res)
+				return nil
 			})
 		}
 	}
+	eg.Wait()
 
-	return eg.Wait()
-}
-
-func unGzip(src io.Reader) (io.Reader, error) {
-	bufSrc := bufio.NewReader(src)
-	if b, err := bufSrc.Peek(2); err != nil {
-		// less than 2 bytes. returns original
-		return bufSrc, nil
-	} else if bytes.Equal(b, gzipMagicBytes) {
-		// gzipped
-		return gzip.NewReader(bufSrc)
-	} else {
-		// raw
-		return bufSrc, nil
-	}
+	return result, result.Err()
 }
 
 func (r *Router) genKeyBase(s3url string) string {
-	if r.option.KeepOriginalName {
+	// local files are named by the file name
+	if r.option.KeepOriginalName || !strings.HasPrefix(s3url, schemeS3+"://") {
+		if _, _, key, err := parseObjectURL(s3url); err == nil {
+			return path.Base(key)
+		}
 		return path.Base(s3url)
 	}
 	sum := sha256.Sum256([]byte(s3url))
 	return fmt.Sprintf("%x", sum)
 }
 
-func (r *Router) route(src io.Reader, keyBase string) (map[destination]buffer, error) {
-	src, err := unGzip(src)
+// route routes records in src to outputs, and returns the number of records which are not routed
+// or routed without valid time by errors.
+func (r *Router) route(src io.Reader, keyBase string) (map[destination]*output, int, error) {
+	entries, err := decompress(src, r.option.SourceCompression)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	dests := make(map[destination]*output)
+	var recordErrors int
+	for _, entry := range entries {
+		n, err := r.routeEntry(entry, keyBase, dests, routeMode{})
+		recordErrors += n
+		if err != nil {
+			for _, out := range dests {
+				if out.sorter != nil {
+					out.sorter.close()
+				}
+			}
+			return nil, recordErrors, err
+		}
+	}
+	sorted, n, err := r.sortOutputs(dests)
+	return sorted, recordErrors + n, err
+}
+
+// sortOutputs encodes records of outputs in order of the time, and splits them into parts
+// by the object limits. Outputs are returned as is when sort-by-time is disabled.
+// It returns the number of records which failed to be encoded.
+func (r *Router) sortOutputs(dests map[destination]*output) (map[destination]*output, int, error) {
+	if !r.option.SortByTime {
+		return dests, 0, nil
+	}
+	sorted := make(map[destination]*output, len(dests))
+	var errs []error
+	var recordErrors int
+	for base, out := range dests {
+		if len(errs) > 0 {
+			out.sorter.close()
+			continue
+		}
+		err := out.sorter.each(func(rec *Record) error {
+			d := r.partDestination(base, sorted)
+			o := sorted[d]
+			if o == nil {
+				o = &output{enc: r.option.newEncoder(), attrs: out.attrs}
+				sorted[d] = o
+			}
+			if err := o.enc.Encode(rec); err != nil {
+				log.Printf("[warn] failed to encode record %s: %#v\n", err, rec)
+				recordErrors++
+				return nil
+			}
+			o.count(rec, r.option.TimeKey)
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", base.String(), err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, recordErrors, errors.Join(errs...)
+	}
+	return sorted, recordErrors, nil
+}
+
+// routeDecision represents how a record is routed by routeEntry.
+type routeDecision struct {
+	line        int
+	record      *Record // nil when the line failed to be parsed
+	keyPrefix   string
+	destination *destination // nil when the record is not routed
+	err         error        // the last error of the record. records with invalid time are still routed
+}
+
+// routeMode customizes routeEntry for Explain and Aggregator.
+type routeMode struct {
+	hook    func(*routeDecision) // receives route decisions of all records and lines which failed to be parsed
+	collect bool                 // collects records in outputs without encoding, to be merged into aggregates
+}
+
+// routeEntry routes records of the entry to outputs. Records which fail to be parsed or routed are
+// logged and skipped, and the number of them is returned.
+func (r *Router) routeEntry(entry sourceEntry, keyBase string, dests map[destination]*output, mode routeMode) (int, error) {
+	src, err := entry.open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	if entry.name != "" {
+		log.Println("[info] route entry", entry.name)
 	}
 	scanner := bufio.NewScanner(src)
-	recordParser := r.option.recordParser
+	recordParser := r.option.newParser()
 	buf := make([]byte, initialBufSize)
 	scanner.Buffer(buf, maxBufSize)
 
-	encs := make(map[destination]encoder)
-
-	for scanner.Scan() {
+	var recordErrors int
+	fail := func(dec *routeDecision, err error) {
+		log.Println("[warn]", err)
+		recordErrors++
+		dec.err = err
+	}
+	for line := 1; scanner.Scan(); line++ {
 		recordBytes := scanner.Bytes()
 		recs, err := recordParser.Parse(recordBytes)
-		if err != nil {
-			if err != SkipLine {
-				log.Println("[warn] failed to parse record", err)
-			}
+		if err == SkipLine {
 			continue
-		}
-		if len(recs) == 0 {
+		} else if err != nil {
+			dec := &routeDecision{line: line}
+			fail(dec, fmt.Errorf("failed to parse record: %w", err))
+			if mode.hook != nil {
+				mode.hook(dec)
+			}
 			continue
 		}
-	RECORD:
 		for _, rec := range recs {
-			if r.option.TimeParse {
-				if ts, ok := rec.parsed[r.option.TimeKey].(string); ok {
-					rec.parsed[r.option.TimeKey], err = r.option.timeParser.Parse(ts)
-					if err != nil {
-						log.Println("[warn] failed to parse time", err)
-					}
-				}
-			}
-			d, err := r.genDestination(rec, keyBase)
-			if err != nil {
-				log.Println("[warn] failed to generate destination", err)
-				continue RECORD
+			dec := &routeDecision{line: line, record: rec}
+			if err := r.routeRecord(rec, entry.name, keyBase, dests, mode.collect, dec, fail); err != nil {
+				return recordErrors, err
 			}
-			enc := encs[d]
-			if enc == nil {
-				enc = r.option.newEncoder()
+			if mode.hook != nil {
+				mode.hook(dec)
 			}
-			if err := enc.Encode(rec); err != nil {
-				log.Printf("[warn] failed to encode record %s: %#v\n", err, rec)
-				continue RECORD
-			}
-			encs[d] = enc
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	return recordErrors, scanner.Err()
+}
+
+// routeRecord routes a record to the output, and sets the decision. Errors of the record are
+// reported to fail, and only errors which abort routing are returned.
+func (r *Router) routeRecord(rec *Record, entryName, keyBase string, dests map[destination]*output, collect bool, dec *routeDecision, fail func(*routeDecision, error)) error {
+	if err := r.prepareRecord(rec, entryName); err != nil {
+		// the record is routed with zero time
+		fail(dec, fmt.Errorf("failed to parse time: %w", err))
+	}
+	prefix, err := r.genKeyPrefix(rec)
+	if err != nil {
+		fail(dec, fmt.Errorf("failed to generate destination: %w", err))
+		return nil
 	}
-	dests := make(map[destination]buffer, len(encs))
-	for d, enc := range encs {
-		dests[d] = enc.Buffer()
+	dec.keyPrefix = prefix
+	d := r.newDestination(prefix, keyBase)
+	if !r.option.SortByTime && !collect {
+		// sorted records are split into parts after sorting, and collected records on merge
+		d = r.partDestination(d, dests)
 	}
-	return dests, nil
+	out := dests[d]
+	if out == nil {
+		// attributes of the destination are rendered by the first record
+		attrs, err := r.attributes.Render(rec)
+		if err != nil {
+			fail(dec, fmt.Errorf("failed to render object attributes: %w", err))
+			return nil
+		}
+		if collect {
+			out = &output{attrs: attrs}
+		} else {
+			out = r.newOutput(attrs)
+		}
+	}
+	switch {
+	case collect:
+		// the scanner reuses the buffer of Raw
+		out.collected = append(out.collected, &Record{Parsed: rec.Parsed, Raw: bytes.Clone(rec.Raw)})
+	case out.sorter != nil:
+		if err := out.sorter.add(rec); err != nil {
+			return err
+		}
+	default:
+		if err := out.enc.Encode(rec); err != nil {
+			fail(dec, fmt.Errorf("failed to encode record: %w", err))
+			return nil
+		}
+	}
+	out.count(rec, r.option.TimeKey)
+	dests[d] = out
+	dec.destination = &d
+	return nil
+}
+
+// newOutput creates an output which encodes records, or sorts them when sort-by-time is enabled.
+// Sorted records are encoded into new outputs by sortOutputs, so the output has no encoder.
+func (r *Router) newOutput(attrs objectAttributes) *output {
+	if r.option.SortByTime {
+		return &output{sorter: newRecordSorter(r.option.TimeKey, r.option.SortSpillRecords), attrs: attrs}
+	}
+	return &output{enc: r.option.newEncoder(), attrs: attrs}
 }
 
-func (r *Router) getS3Object(ctx context.Context, s3url string) (io.ReadCloser, error) {
-	u, err := url.Parse(s3url)
+func (r *Router) sourceStorage(s3url string) (storage Storage, scheme, bucket, key string, err error) {
+	scheme, bucket, key, err = parseObjectURL(s3url)
 	if err != nil {
-		return nil, err
+		return nil, "", "", "", err
 	}
-	if u.Scheme != "s3" {
-		return nil, errors.New("s3:// required")
+	storage, ok := r.sources[scheme]
+	if !ok {
+		return nil, "", "", "", fmt.Errorf("unsupported scheme %s://", scheme)
 	}
+	return storage, scheme, bucket, key, nil
+}
 
-	out, err := r.s3.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(u.Host),
-		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+// loopGuard checks metadata of the source object by HeadObject before downloading,
+// and returns ErrRoutedObject when the object is an already routed object.
+func (r *Router) loopGuard(ctx context.Context, s3url string) (*s3.HeadObjectOutput, error) {
+	storage, _, bucket, key, err := r.sourceStorage(s3url)
+	if err != nil {
+		return nil, err
+	}
+	out, err := storage.Head(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		return nil, err
 	}
-	// loop guard
-	for name, value := range out.Metadata {
-		if strings.ToLower(name) == MetaHeaderName {
-			out.Body.Close()
-			return nil, fmt.Errorf("%s seems to be an already routed object. original: %s", s3url, value)
+	if original, ok := r.routedFrom(out.Metadata); ok {
+		return nil, fmt.Errorf("%s %w. original: %s", s3url, ErrRoutedObject, original)
+	}
+	return out, nil
+}
+
+// routedFrom returns the original source URL in metadata of a routed object.
+func (r *Router) routedFrom(metadata map[string]string) (string, bool) {
+	for name, value := range metadata {
+		if strings.ToLower(name) == r.option.MetaHeaderName {
+			return value, true
 		}
 	}
+	return "", false
+}
 
+func (r *Router) getObject(ctx context.Context, s3url string) (io.ReadCloser, error) {
+	storage, _, bucket, key, err := r.sourceStorage(s3url)
+	if err != nil {
+		return nil, err
+	}
+	out, err := storage.Get(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
 	return out.Body, nil
 }
 
-func (r *Router) genDestination(rec *record, name string) (destination, error) {
-	prefix, err := r.genKeyPrefix(rec)
-	if err != nil {
-		return destination{}, err
+// prepareRecord sets the entry name and parses the time of the record.
+// An error of time parsing is returned, but the record is still routable.
+func (r *Router) prepareRecord(rec *Record, entryName string) error {
+	if entryName != "" {
+		rec.Parsed[EntryKey] = entryName
+	}
+	if !r.option.TimeParse {
+		return nil
 	}
+	ts, ok := rec.Parsed[r.option.TimeKey].(string)
+	if !ok {
+		return nil
+	}
+	t, err := r.option.timeParser.Parse(ts)
+	rec.Parsed[r.option.TimeKey] = t
+	return err
+}
+
+// newDestination returns a destination of the key prefix and the base name.
+func (r *Router) newDestination(prefix, name string) destination {
 	key := path.Join(prefix, name)
-	if r.option.Gzip && !strings.HasSuffix(name, gzipSuffix) {
-		key = key + gzipSuffix
+	if suffix := r.option.keySuffix; suffix != "" && !strings.HasSuffix(name, suffix) {
+		key = key + suffix
 	}
 	return destination{
-		Bucket: r.option.Bucket,
+		Scheme: r.option.destScheme,
+		Bucket: r.option.destBucket,
 		Key:    key,
-	}, nil
+	}
 }
 
-func (r *Router) putToS3(ctx context.Context, dest destination, body io.ReadSeeker, meta map[string]string) error {
-	r.sem.Acquire(ctx, 1)
+// partDestination returns the part of the destination which accepts the next record.
+// When the output exceeds max-object-bytes or max-object-records, the destination rolls over
+// to a new part named "<keybase>-0001" (before the key suffix). Part names are deterministic
+// for the same source, so rerouting overwrites the same parts. The current part is kept in
+// the output of the base destination, so filled parts are not checked again.
+func (r *Router) partDestination(d destination, dests map[destination]*output) destination {
+	if r.option.MaxObjectBytes == 0 && r.option.MaxObjectRecords == 0 {
+		return d
+	}
+	base := dests[d]
+	if base == nil {
+		return d
+	}
+	// full parts are skipped from the current part of the base
+	part, suffix := base.part, r.option.keySuffix
+	for {
+		p := d
+		if part > 0 {
+			p.Key = strings.TrimSuffix(d.Key, suffix) + fmt.Sprintf("-%04d", part) + suffix
+		}
+		if !r.exceedsObjectLimit(dests[p]) {
+			base.part = part
+			return p
+		}
+		part++
+	}
+}
+
+// exceedsObjectLimit reports whether the output reaches max-object-bytes or max-object-records.
+// The size is measured by the (compressed) body buffered so far.
+func (r *Router) exceedsObjectLimit(out *output) bool {
+	switch {
+	case out == nil:
+		return false
+	case r.option.MaxObjectRecords > 0 && out.records >= r.option.MaxObjectRecords:
+		return true
+	case r.option.MaxObjectBytes > 0 && out.size() >= r.option.MaxObjectBytes:
+		return true
+	}
+	return false
+}
+
+// putObjectWithRetry puts an object with retries, and sets the outcome to res.
+func (r *Router) putObjectWithRetry(ctx context.Context, dest destination, body *bytes.Reader, meta map[string]string, attrs objectAttributes, res *OutputResult) {
+	if err := r.sem.Acquire(ctx, 1); err != nil {
+		// ctx is canceled before a slot is acquired
+		res.Status, res.Err = OutputFailed, err
+		return
+	}
 	defer r.sem.Release(1)
 
+	if r.option.SkipUnchanged {
+		if unchanged, err := r.isUnchanged(ctx, dest, body); err != nil {
+			log.Println("[warn] failed to check the destination", dest.String(), err)
+		} else if unchanged {
+			log.Println("[info] skip put to", dest.String(), "already has the same content")
+			res.Status = OutputSkipped
+			return
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		res.Attempts = attempt + 1
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			res.Status, res.Err = OutputFailed, err
+			return
+		}
+		err := r.putObject(ctx, dest, body, meta, attrs)
+		if err == nil {
+			res.Status, res.Err = OutputPut, nil
+			return
+		}
+		if isPreconditionFailed(err) {
+			if r.option.WritePolicy == WritePolicySkipIfExists {
+				log.Println("[info] skip put to", dest.String(), "already exists")
+				res.Status, res.Err = OutputExists, nil
+			} else {
+				log.Println("[error] failed to put to", dest.String(), ErrDestinationExists)
+				res.Status, res.Err = OutputFailed, ErrDestinationExists
+			}
+			return
+		}
+		res.Status, res.Err = OutputFailed, err
+		if attempt >= r.option.PutRetries || ctx.Err() != nil {
+			log.Println("[error] failed to put to", dest.String(), err)
+			return
+		}
+		wait := backoff(r.option.putRetryWait, attempt)
+		log.Printf("[warn] failed to put to %s: %s. retrying after %s", dest.String(), err, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// backoff returns a jittered exponential backoff duration for the attempt.
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := base << attempt
+	if d <= 0 || d > maxRetryWait {
+		d = maxRetryWait
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isUnchanged reports whether the destination already has the same content as body.
+// It compares MD5 of the body with ETag, so objects encrypted by SSE-KMS are never unchanged.
+func (r *Router) isUnchanged(ctx context.Context, dest destination, body *bytes.Reader) (bool, error) {
+	out, err := r.dest.Head(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(dest.Bucket),
+		Key:    aws.String(dest.Key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	h := md5.New()
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(h, body); err != nil {
+		return false, err
+	}
+	return strings.Trim(aws.ToString(out.ETag), `"`) == hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (r *Router) putObject(ctx context.Context, dest destination, body io.ReadSeeker, meta map[string]string, attrs objectAttributes) error {
 	in := &s3.PutObjectInput{
 		Bucket:   &dest.Bucket,
 		Key:      &dest.Key,
 		Body:     body,
 		Metadata: meta,
 	}
+	if v := r.option.contentType; v != "" {
+		in.ContentType = aws.String(v)
+	}
+	if v := r.option.encoding; v != "" {
+		in.ContentEncoding = aws.String(v)
+	}
+	if v := r.option.CacheControl; v != "" {
+		in.CacheControl = aws.String(v)
+	}
+	if v := r.option.ContentDisposition; v != "" {
+		in.ContentDisposition = aws.String(v)
+	}
+	if r.option.BucketKeyEnabled {
+		in.BucketKeyEnabled = aws.Bool(true)
+	}
+	if v := r.option.ExpectedBucketOwner; v != "" {
+		in.ExpectedBucketOwner = aws.String(v)
+	}
+	if r.option.WritePolicy != WritePolicyOverwrite {
+		in.IfNoneMatch = aws.String("*")
+	}
+	attrs.apply(in)
+	if attrs.contentType != "" {
+		// the body is not encoded by the router
+		in.ContentType, in.ContentEncoding = aws.String(attrs.contentType), nil
+	}
 	log.Println("[info] starting put to", dest.String())
-	_, err := r.s3.PutObject(ctx, in)
+	_, err := r.dest.Put(ctx, in)
 	if err == nil {
 		log.Println("[info] completed put to", dest.String())
 	}
 	return err
 }
 
-type record struct {
-	parsed map[string]interface{}
-	raw    []byte
-}
-
-func newRecord(raw []byte) *record {
-	return &record{
-		parsed: make(map[string]interface{}),
-		raw:    raw,
-	}
-}
-
 type destination struct {
+	Scheme string
 	Bucket string
 	Key    string
 }
 
 func (d destination) String() string {
+	return objectURL(d.Scheme, d.Bucket, d.Key)
+}
+
+// URL schemes of objects
+const (
+	schemeS3   = "s3"
+	schemeFile = "file"
+)
+
+// parseObjectURL parses s3://bucket/key or file:///path/to/file.
+// For file:// URLs, bucket is empty and key is an absolute path.
+func parseObjectURL(s string) (scheme, bucket, key string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", "", err
+	}
+	switch u.Scheme {
+	case schemeS3:
+		return u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/"), nil
+	case schemeFile:
+		return u.Scheme, "", u.Path, nil
+	default:
+		return "", "", "", errors.New("s3:// or file:// required")
+	}
+}
+
+func objectURL(scheme, bucket, key string) string {
 	u := url.URL{
-		Scheme: "s3",
-		Host:   d.Bucket,
-		Path:   d.Key,
+		Scheme: scheme,
+		Host:   bucket,
+		Path:   key,
+	}
+	if scheme == schemeFile {
+		u.Host = ""
+		u.Path = path.Join(bucket, key)
 	}
 	return u.String()
 }
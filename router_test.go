@@ -2,16 +2,18 @@ package router_test
 
 import (
 	"bytes"
-	"compress/gzip"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
-	"io"
-	"io/ioutil"
-	"mime/multipart"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/google/go-cmp/cmp"
 
 	router "github.com/kayac/s3-object-router"
@@ -23,14 +25,8 @@ func TestMain(t *testing.T) {
 	flag.Parse()
 }
 
-type testRouterConfig struct {
-	router.Option
-	Sources        []string `json:"sources"`
-	EnableGzipTest bool     `json:"enable_gzip_test"`
-}
-
 func TestRouter(t *testing.T) {
-	cases, err := ioutil.ReadDir("testdata")
+	cases, err := os.ReadDir("testdata")
 	if err != nil {
 		t.Logf("can not read testdata:%s", err)
 		t.FailNow()
@@ -40,127 +36,210 @@ func TestRouter(t *testing.T) {
 			continue
 		}
 		t.Run(c.Name(), func(t *testing.T) {
-			testRouter(t, c.Name())
+			results, err := router.RunGoldenCase(filepath.Join("testdata", c.Name()), *updateFlag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 {
+				t.Error("no sources")
+			}
+			for _, res := range results {
+				if d := cmp.Diff(res.Expected, res.Routed); !res.Updated && d != "" {
+					t.Error("unexpected routed data:", res.Source, d)
+				}
+			}
 		})
 	}
 }
 
-func testRouter(t *testing.T, caseDirName string) {
-	fp, err := os.Open(filepath.Join("testdata", caseDirName, "config.json"))
+func readRouterGolden(t *testing.T, goldenFile string) map[string]string {
+	t.Helper()
+	fp, err := os.Open(goldenFile)
 	if err != nil {
-		t.Logf("can not open test config:%s", err)
-		t.FailNow()
+		t.Fatalf("can not open golden file: %s", err)
 	}
 	defer fp.Close()
-	decoder := json.NewDecoder(fp)
-	var config testRouterConfig
-	if err := decoder.Decode(&config); err != nil {
-		t.Logf("can not route test config:%s", err)
-		t.FailNow()
+	res, err := router.ReadGolden(fp)
+	if err != nil {
+		t.Fatalf("can not read golden file: %s", err)
 	}
+	return res
+}
 
-	r, err := router.New(&config.Option)
+func TestRunAll(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	var srcs []string
+	for i := 0; i < 20; i++ {
+		// field orders differ for each object, so parser state must not be shared
+		body := "#Version: 1.0\n#Fields: date time x-edge-location\n2019-12-04\t21:02:31\tLAX1\n"
+		if i%2 == 1 {
+			body = "#Version: 1.0\n#Fields: x-edge-location date time\nLAX1\t2019-12-04\t21:02:31\n"
+		}
+		key := fmt.Sprintf("logs/%02d.log", i)
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(body),
+		})
+		srcs = append(srcs, "s3://src/"+key)
+	}
+	opt := &router.Option{
+		Bucket:            "dest",
+		KeyPrefix:         "{{ .x_edge_location }}/{{ .datetime.Format `2006-01-02` }}",
+		Parser:            "cloudfront",
+		TimeParse:         true,
+		TimeKey:           "datetime",
+		TimeFormat:        "2006-01-02T15:04:05Z07:00",
+		PutS3:             true,
+		KeepOriginalName:  true,
+		SourceConcurrency: 4,
+	}
+	r, err := router.New(opt)
 	if err != nil {
-		t.Error(err)
-		t.FailNow()
+		t.Fatal(err)
 	}
-	sfps := make(map[string]io.ReadCloser, len(config.Sources))
-	defer func() {
-		for _, sfp := range sfps {
-			sfp.Close()
-		}
-	}()
-	for _, src := range config.Sources {
-		path := filepath.Join("testdata", src)
-		basename := filepath.Base(path)
-		sfp, err := os.Open(path)
-		if err != nil {
-			t.Error(err)
-			t.FailNow()
-		}
-		if config.EnableGzipTest {
-			var raw, gzipped bytes.Buffer
-			gw := gzip.NewWriter(&gzipped)
-			w := io.MultiWriter(&raw, gw)
-			io.Copy(w, sfp)
-			sfp.Close()
-			gw.Close()
-			sfps[basename] = ioutil.NopCloser(&raw)
-			sfps[basename+"_gzipped"] = ioutil.NopCloser(&gzipped)
-		} else {
-			sfps[basename] = sfp
-		}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	if err := r.RunAll(ctx, srcs); err != nil {
+		t.Fatal(err)
 	}
-	for name, sfp := range sfps {
-		res, err := router.DoTestRoute(r, sfp, "s3://example-bucket/path/to/example-object")
-		if err != nil {
-			t.Error(err)
-			continue
-		}
-		goldenFile := filepath.Join("testdata", caseDirName, name+".golden")
-		if *updateFlag {
-			writeRouterGolden(t, goldenFile, res)
-		}
-		expected := readRouterGolden(t, goldenFile)
-		if d := cmp.Diff(expected, res); d != "" {
-			t.Error("unexpected routed data:", d)
+	for i := 0; i < 20; i++ {
+		if storage.Object("dest", fmt.Sprintf("LAX1/2019-12-04/%02d.log", i)) == nil {
+			t.Errorf("%02d.log is not routed", i)
 		}
 	}
-}
-
-const (
-	routerGoldenBoundary = "----s3-object-router-test----"
-)
 
-func writeRouterGolden(t *testing.T, goldenFile string, res map[string]string) {
-	t.Helper()
-	fp, err := os.OpenFile(
-		goldenFile,
-		os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
-		0644,
-	)
-	if err != nil {
-		t.Logf("can not create golden file: %s", err)
-		t.FailNow()
+	// a failure does not stop routing the others
+	dest := router.NewMemoryStorage()
+	r.SetDestinationStorage(dest)
+	err = r.RunAll(ctx, append([]string{"s3://src/logs/missing.log"}, srcs...))
+	var notFound *types.NotFound
+	if !errors.As(err, &notFound) || !strings.Contains(err.Error(), "s3://src/logs/missing.log") {
+		t.Errorf("unexpected error %v", err)
 	}
-	defer fp.Close()
-	w := multipart.NewWriter(fp)
-	w.SetBoundary(routerGoldenBoundary)
-	for dest, content := range res {
-		if err := w.WriteField(dest, content); err != nil {
-			t.Logf("can not write golden data: %s", err)
-			t.FailNow()
+	for i := 0; i < 20; i++ {
+		if dest.Object("dest", fmt.Sprintf("LAX1/2019-12-04/%02d.log", i)) == nil {
+			t.Errorf("%02d.log is not routed", i)
 		}
 	}
-	w.Close()
 }
 
-func readRouterGolden(t *testing.T, goldenFile string) map[string]string {
-	t.Helper()
-	fp, err := os.Open(goldenFile)
-	res := map[string]string{}
-	if err != nil {
-		t.Logf("can not open golden file: %s", err)
-		t.FailNow()
-	}
-	defer fp.Close()
-	r := multipart.NewReader(fp, routerGoldenBoundary)
-	for {
-		part, err := r.NextPart()
-		if err == io.EOF {
-			break
+func TestRunSplitObjects(t *testing.T) {
+	ctx := context.Background()
+	line := `{"tag":"a"}` + "\n" // 12 bytes
+	for _, tc := range []struct {
+		name    string
+		setOpt  func(*router.Option)
+		suffix  string
+		records []int
+	}{
+		{"records", func(o *router.Option) { o.MaxObjectRecords = 2 }, "", []int{2, 2, 1}},
+		{"bytes", func(o *router.Option) { o.MaxObjectBytes = 30 }, "", []int{3, 2}},
+		{"gzip", func(o *router.Option) { o.MaxObjectRecords = 2; o.Compression = "gzip" }, ".gz", []int{2, 2, 1}},
+	} {
+		storage := router.NewMemoryStorage()
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String("x.log"),
+			Body:   strings.NewReader(strings.Repeat(line, 5)),
+		})
+		opt := testRouterOption("dest")
+		opt.KeyPrefix = "{{ .tag }}"
+		tc.setOpt(opt)
+		r, err := router.New(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetSourceStorage("s3", storage)
+		r.SetDestinationStorage(storage)
+		// rerun overwrites the same parts
+		for i := 0; i < 2; i++ {
+			if err := r.Run(ctx, "s3://src/x.log"); err != nil {
+				t.Fatal(tc.name, err)
+			}
 		}
+		list, err := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dest")})
 		if err != nil {
-			t.Logf("can not get golden next part: %s", err)
-			t.FailNow()
+			t.Fatal(err)
+		}
+		records := make(map[string]int)
+		for _, obj := range list.Contents {
+			body, err := router.DoTestDecompress(bytes.NewReader(storage.Object("dest", *obj.Key).Body), "auto")
+			if err != nil {
+				t.Fatal(tc.name, err)
+			}
+			records[*obj.Key] = strings.Count(body[""], "\n")
+		}
+		// parts are named before the suffix of the compression
+		expected := map[string]int{"a/x.log" + tc.suffix: tc.records[0]}
+		for i, n := range tc.records[1:] {
+			expected[fmt.Sprintf("a/x.log-%04d%s", i+1, tc.suffix)] = n
+		}
+		if d := cmp.Diff(expected, records); d != "" {
+			t.Errorf("%s: unexpected parts: %s", tc.name, d)
 		}
-		content, err := ioutil.ReadAll(part)
+	}
+}
+
+func TestRunSortByTime(t *testing.T) {
+	ctx := context.Background()
+	// seconds of datetime. -1 is a record without time, -2 is a record with invalid time,
+	// and n is the line number for stability
+	seconds := []int{5, 3, -1, 8, 1, 3, -2, 2, 5, 6}
+	var src strings.Builder
+	for n, s := range seconds {
+		switch s {
+		case -1:
+			fmt.Fprintf(&src, `{"tag":"a","n":%d}`+"\n", n)
+			continue
+		case -2:
+			fmt.Fprintf(&src, `{"tag":"a","n":%d,"datetime":"yesterday"}`+"\n", n)
+			continue
+		}
+		fmt.Fprintf(&src, `{"tag":"a","n":%d,"datetime":"2020-01-01T00:00:0%dZ"}`+"\n", n, s)
+	}
+	for _, tc := range []struct {
+		format string
+		spill  int
+	}{{"none", 100}, {"none", 3}, {"json", 3}} {
+		storage := router.NewMemoryStorage()
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String("x.log"),
+			Body:   strings.NewReader(src.String()),
+		})
+		opt := testRouterOption("dest")
+		opt.KeyPrefix = "{{ .tag }}"
+		opt.SortByTime = true
+		opt.ObjectFormat = tc.format
+		opt.SortSpillRecords = tc.spill
+		opt.MaxObjectRecords = 4
+		r, err := router.New(opt)
 		if err != nil {
-			t.Logf("can not read golden part: %s", err)
-			t.FailNow()
+			t.Fatal(err)
+		}
+		r.SetSourceStorage("s3", storage)
+		r.SetDestinationStorage(storage)
+		if err := r.Run(ctx, "s3://src/x.log"); err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, key := range []string{"a/x.log", "a/x.log-0001", "a/x.log-0002"} {
+			obj := storage.Object("dest", key)
+			if obj == nil {
+				t.Fatalf("%v: %s is not found", tc, key)
+			}
+			lines = append(lines, strings.Split(strings.TrimSpace(string(obj.Body)), "\n")...)
+		}
+		var order []string
+		for _, line := range lines {
+			// records are restored from spilled runs
+			order = append(order, line[strings.Index(line, `"n":`)+4:][:1])
+		}
+		expected := []string{"4", "7", "1", "5", "0", "8", "9", "3", "2", "6"}
+		if d := cmp.Diff(expected, order); d != "" {
+			t.Errorf("%v: unexpected order: %s", tc, d)
 		}
-		res[part.FormName()] = string(content)
-		part.Close()
 	}
-	return res
 }
@@ -0,0 +1,202 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ServeOption represents option values of Serve
+type ServeOption struct {
+	AggregateOption
+	QueueURL    string // URL of SQS queue which receives S3 event notifications
+	SQSEndpoint string // custom SQS endpoint URL. e.g. http://localhost:9324 for ElasticMQ
+}
+
+// s3Notification represents a S3 event notification in a SQS message.
+type s3Notification struct {
+	Event   string `json:"Event"` // s3:TestEvent
+	Records []struct {
+		S3 struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"` // URL encoded
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// sourceURLs returns URLs of source objects in the notification.
+func (n *s3Notification) sourceURLs() ([]string, error) {
+	urls := make([]string, 0, len(n.Records))
+	for _, rec := range n.Records {
+		key, err := url.QueryUnescape(rec.S3.Object.Key)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, objectURL(schemeS3, rec.S3.Bucket.Name, key))
+	}
+	return urls, nil
+}
+
+// serveFlushMargin is the time to put flushed destinations, required in the visibility timeout
+// in addition to the flush interval.
+var serveFlushMargin = time.Minute
+
+// checkVisibilityTimeout returns an error when messages can be redelivered before their records are flushed.
+// visibilityTimeout is the VisibilityTimeout attribute (seconds) of the queue.
+func checkVisibilityTimeout(visibilityTimeout string, opt *ServeOption) error {
+	if opt.FlushInterval <= 0 {
+		return errors.New("flush interval must be positive to delete messages before the visibility timeout")
+	}
+	sec, err := strconv.Atoi(visibilityTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid visibility timeout of the queue %q: %w", visibilityTimeout, err)
+	}
+	if vt := time.Duration(sec) * time.Second; vt < opt.FlushInterval+serveFlushMargin {
+		return fmt.Errorf(
+			"visibility timeout of the queue %s is too short. it must be longer than the flush interval %s + %s",
+			vt, opt.FlushInterval, serveFlushMargin,
+		)
+	}
+	return nil
+}
+
+// Serve receives S3 event notifications from the SQS queue, and routes source objects
+// by an Aggregator until ctx is canceled. A message is deleted after all destinations
+// which have records of its source objects are flushed. Destinations are flushed on return.
+// Serve fails at startup when the visibility timeout of the queue is not longer than FlushInterval
+// with a margin, because messages would be redelivered before flush.
+func (r *Router) Serve(ctx context.Context, opt *ServeOption) error {
+	if opt.QueueURL == "" {
+		return errors.New("queue URL must not be empty")
+	}
+	awsConf, err := loadAWSConfig(ctx, r.option)
+	if err != nil {
+		return err
+	}
+	client := sqs.NewFromConfig(awsConf, func(o *sqs.Options) {
+		if opt.SQSEndpoint != "" {
+			o.BaseEndpoint = aws.String(opt.SQSEndpoint)
+		}
+	})
+	attrs, err := client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(opt.QueueURL),
+		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameVisibilityTimeout},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get attributes of the queue: %w", err)
+	}
+	if err := checkVisibilityTimeout(attrs.Attributes[string(types.QueueAttributeNameVisibilityTimeout)], opt); err != nil {
+		return err
+	}
+
+	agg := r.NewAggregator(opt.AggregateOption)
+	// messages are deleted and destinations are flushed after ctx is canceled
+	bgCtx := context.WithoutCancel(ctx)
+	deleteMessage := func(receiptHandle *string) {
+		_, err := client.DeleteMessage(bgCtx, &sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(opt.QueueURL),
+			ReceiptHandle: receiptHandle,
+		})
+		if err != nil {
+			log.Println("[warn] failed to delete message", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := agg.FlushExpired(bgCtx); err != nil {
+					log.Println("[error] failed to flush", err)
+				}
+			}
+		}
+	}()
+
+	log.Println("[info] serving", opt.QueueURL)
+	for ctx.Err() == nil {
+		out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(opt.QueueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Println("[warn] failed to receive messages", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		for _, msg := range out.Messages {
+			if err := r.serveMessage(ctx, agg, aws.ToString(msg.Body), func() { deleteMessage(msg.ReceiptHandle) }); err != nil {
+				log.Println("[error]", err)
+			}
+		}
+	}
+	wg.Wait()
+	log.Println("[info] flushing all destinations")
+	return agg.Flush(bgCtx)
+}
+
+// serveMessage adds source objects of the message to the aggregator.
+// del is called when all source objects are acked.
+func (r *Router) serveMessage(ctx context.Context, agg *Aggregator, body string, del func()) error {
+	var n s3Notification
+	if err := json.Unmarshal([]byte(body), &n); err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	if n.Event == "s3:TestEvent" {
+		del()
+		return nil
+	}
+	srcs, err := n.sourceURLs()
+	if err != nil {
+		return fmt.Errorf("invalid message: %w", err)
+	}
+	if len(srcs) == 0 {
+		del()
+		return nil
+	}
+	var mu sync.Mutex
+	remaining := len(srcs)
+	ack := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if remaining--; remaining == 0 {
+			del()
+		}
+	}
+	for _, src := range srcs {
+		err := agg.Add(ctx, src, ack)
+		if errors.Is(err, ErrRoutedObject) {
+			log.Println("[warn]", err)
+			ack()
+		} else if err != nil {
+			// the message is redelivered after the visibility timeout
+			return err
+		}
+	}
+	return nil
+}
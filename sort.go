@@ -0,0 +1,234 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultSortSpillRecords is the default number of records of a destination kept in memory for sorting.
+var DefaultSortSpillRecords = 100000
+
+// recordSorter sorts records of a destination by the time stably.
+// Records beyond the limit are sorted and spilled to a temporary file as a run,
+// and runs are merged on each.
+type recordSorter struct {
+	timeKey string
+	limit   int
+	recs    []*Record
+	bytes   int
+	runs    []string // names of spilled files
+}
+
+func newRecordSorter(timeKey string, limit int) *recordSorter {
+	return &recordSorter{timeKey: timeKey, limit: limit}
+}
+
+// spilledRecord represents a record in a spilled run.
+// Values of Parsed are round-tripped by JSON, and the time is restored.
+type spilledRecord struct {
+	Time   *time.Time             `json:"t,omitempty"`
+	Parsed map[string]interface{} `json:"p"`
+	Raw    []byte                 `json:"r,omitempty"`
+}
+
+func (s *recordSorter) add(rec *Record) error {
+	// Raw may refer the buffer of the scanner
+	rec.Raw = bytes.Clone(rec.Raw)
+	s.recs = append(s.recs, rec)
+	if rec.Raw != nil {
+		s.bytes += len(rec.Raw) + 1
+	} else if b, err := json.Marshal(rec.Parsed); err == nil {
+		s.bytes += len(b) + 1
+	}
+	if len(s.recs) >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// size returns the approximate size of records before encoding.
+func (s *recordSorter) size() int {
+	return s.bytes
+}
+
+// spill writes sorted records in memory to a temporary file. The file is closed after written,
+// so file descriptors are not held by runs until the merge.
+func (s *recordSorter) spill() error {
+	sortRecordsByTime(s.recs, s.timeKey)
+	f, err := os.CreateTemp("", "s3-object-router-sort-")
+	if err != nil {
+		return fmt.Errorf("failed to spill records: %w", err)
+	}
+	defer f.Close()
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, rec := range s.recs {
+		sr := spilledRecord{Parsed: rec.Parsed, Raw: rec.Raw}
+		if t, ok := rec.Parsed[s.timeKey].(time.Time); ok {
+			sr.Time = &t
+		}
+		if err := enc.Encode(sr); err != nil {
+			return fmt.Errorf("failed to spill records: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to spill records: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to spill records: %w", err)
+	}
+	s.recs = nil
+	return nil
+}
+
+// each calls fn for all records in order of the time, and removes spilled runs.
+func (s *recordSorter) each(fn func(*Record) error) error {
+	defer s.close()
+	sortRecordsByTime(s.recs, s.timeKey)
+	if len(s.runs) == 0 {
+		for _, rec := range s.recs {
+			if err := fn(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// k-way merge of runs. records in memory are the last run.
+	var h runHeap
+	for i, name := range s.runs {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("failed to read spilled records: %w", err)
+		}
+		defer f.Close()
+		run := &sortRun{index: i, dec: json.NewDecoder(bufio.NewReader(f)), timeKey: s.timeKey}
+		if err := run.next(); err != nil {
+			return err
+		}
+		if run.rec != nil {
+			h = append(h, run)
+		}
+	}
+	if len(s.recs) > 0 {
+		run := &sortRun{index: len(s.runs), recs: s.recs, timeKey: s.timeKey}
+		run.next()
+		h = append(h, run)
+	}
+	heap.Init(&h)
+	for h.Len() > 0 {
+		run := h[0]
+		if err := fn(run.rec); err != nil {
+			return err
+		}
+		if err := run.next(); err != nil {
+			return err
+		}
+		if run.rec == nil {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return nil
+}
+
+// close removes spilled runs.
+func (s *recordSorter) close() {
+	for _, name := range s.runs {
+		os.Remove(name)
+	}
+	s.runs = nil
+	s.recs = nil
+}
+
+// sortRun reads sorted records from a spilled file or a slice.
+type sortRun struct {
+	index   int
+	dec     *json.Decoder
+	recs    []*Record
+	timeKey string
+	rec     *Record
+}
+
+// next reads the next record into run.rec. run.rec is nil at the end.
+func (run *sortRun) next() error {
+	run.rec = nil
+	if run.dec == nil {
+		if len(run.recs) > 0 {
+			run.rec, run.recs = run.recs[0], run.recs[1:]
+		}
+		return nil
+	}
+	var sr spilledRecord
+	if err := run.dec.Decode(&sr); err == io.EOF {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read spilled records: %w", err)
+	}
+	if sr.Parsed == nil {
+		return errors.New("failed to read spilled records: no fields")
+	}
+	if sr.Time != nil {
+		sr.Parsed[run.timeKey] = *sr.Time
+	}
+	run.rec = &Record{Parsed: sr.Parsed, Raw: sr.Raw}
+	return nil
+}
+
+// runHeap orders runs by the time of the current record, and by the run index for stability.
+type runHeap []*sortRun
+
+func (h runHeap) Len() int { return len(h) }
+func (h runHeap) Less(i, j int) bool {
+	ti, iok := recordTime(h[i].rec, h[i].timeKey)
+	tj, jok := recordTime(h[j].rec, h[j].timeKey)
+	if lessTime(ti, iok, tj, jok) {
+		return true
+	}
+	if lessTime(tj, jok, ti, iok) {
+		return false
+	}
+	return h[i].index < h[j].index
+}
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*sortRun)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return run
+}
+
+// sortRecordsByTime sorts records by the parsed time stably. Records without time are placed last.
+func sortRecordsByTime(recs []*Record, timeKey string) {
+	sort.SliceStable(recs, func(i, j int) bool {
+		ti, iok := recordTime(recs[i], timeKey)
+		tj, jok := recordTime(recs[j], timeKey)
+		return lessTime(ti, iok, tj, jok)
+	})
+}
+
+// recordTime returns the parsed time of the record. The zero time set by a failure of
+// time parsing is treated as missing.
+func recordTime(rec *Record, timeKey string) (time.Time, bool) {
+	t, ok := rec.Parsed[timeKey].(time.Time)
+	return t, ok && !t.IsZero()
+}
+
+// lessTime compares times which may be missing. A missing time is greater than any time.
+func lessTime(ti time.Time, iok bool, tj time.Time, jok bool) bool {
+	if iok && jok {
+		return ti.Before(tj)
+	}
+	return iok && !jok
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Storage represents an object storage for source and destination objects.
+// Input and output types of S3 API are used for all implementations.
+// Implementations return *types.NoSuchKey by Get and *types.NotFound by Head for missing objects.
+// Put supports IfNoneMatch "*" and returns ErrPreconditionFailed when the object already exists.
+type Storage interface {
+	Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	Put(ctx context.Context, in *s3.PutObjectInput) (*s3.PutObjectOutput, error)
+	Head(ctx context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	List(ctx context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error)
+	Delete(ctx context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	Copy(ctx context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error)
+	GetTagging(ctx context.Context, in *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error)
+	PutTagging(ctx context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error)
+}
+
+// ErrPreconditionFailed is an error returned by Storage.Put for a failed conditional write.
+var ErrPreconditionFailed = &smithy.GenericAPIError{
+	Code:    "PreconditionFailed",
+	Message: "At least one of the pre-conditions you specified did not hold",
+}
+
+// isPreconditionFailed reports whether err is a failed conditional write.
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == ErrPreconditionFailed.Code
+}
+
+// S3Storage is a Storage for Amazon S3.
+type S3Storage struct {
+	client *s3.Client
+}
+
+// NewS3Storage creates a S3Storage.
+func NewS3Storage(client *s3.Client) *S3Storage {
+	return &S3Storage{client: client}
+}
+
+// Get gets an object.
+func (s *S3Storage) Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return s.client.GetObject(ctx, in)
+}
+
+// Put puts an object.
+func (s *S3Storage) Put(ctx context.Context, in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return s.client.PutObject(ctx, in)
+}
+
+// Head gets metadata of an object.
+func (s *S3Storage) Head(ctx context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	return s.client.HeadObject(ctx, in)
+}
+
+// List lists objects.
+func (s *S3Storage) List(ctx context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	return s.client.ListObjectsV2(ctx, in)
+}
+
+// Delete deletes an object.
+func (s *S3Storage) Delete(ctx context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	return s.client.DeleteObject(ctx, in)
+}
+
+// Copy copies an object.
+func (s *S3Storage) Copy(ctx context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	return s.client.CopyObject(ctx, in)
+}
+
+// GetTagging gets tags of an object.
+func (s *S3Storage) GetTagging(ctx context.Context, in *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error) {
+	return s.client.GetObjectTagging(ctx, in)
+}
+
+// PutTagging puts tags of an object.
+func (s *S3Storage) PutTagging(ctx context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	return s.client.PutObjectTagging(ctx, in)
+}
+
+// splitCopySource splits CopySource "bucket/key" of CopyObject. The key is URL encoded.
+func splitCopySource(src string) (bucket, key string, err error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+	if !ok {
+		return "", "", fmt.Errorf("invalid copy source %s", src)
+	}
+	key, err = url.PathUnescape(key)
+	return bucket, key, err
+}
+
+// copySource returns CopySource of CopyObject for the object.
+func copySource(bucket, key string) string {
+	return bucket + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+// parseTagging parses Tagging of PutObject such as "key1=value1&key2=value2".
+func parseTagging(tagging string) []types.Tag {
+	values, _ := url.ParseQuery(tagging)
+	tags := make([]types.Tag, 0, len(values))
+	for key := range values {
+		tags = append(tags, types.Tag{Key: aws.String(key), Value: aws.String(values.Get(key))})
+	}
+	sort.Slice(tags, func(i, j int) bool { return *tags[i].Key < *tags[j].Key })
+	return tags
+}
@@ -0,0 +1,238 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// LocalStorage is a Storage for local filesystem.
+// A bucket is a directory and a key is a file path relative to the directory.
+// Metadata, tags and other attributes of objects are not stored.
+type LocalStorage struct{}
+
+// NewLocalStorage creates a LocalStorage.
+func NewLocalStorage() *LocalStorage {
+	return &LocalStorage{}
+}
+
+// ErrKeyOutOfBucket is returned when a key points outside of the bucket directory. e.g. "../etc/passwd"
+var ErrKeyOutOfBucket = errors.New("key is out of the bucket")
+
+// path returns the file path of the key in the bucket directory.
+// Keys which escape the directory by ".." are rejected.
+func (s *LocalStorage) path(bucket, key *string) (string, error) {
+	root := aws.ToString(bucket)
+	name := filepath.Join(root, filepath.FromSlash(aws.ToString(key)))
+	if root == "" {
+		// absolute paths of file:// URLs
+		return name, nil
+	}
+	rel, err := filepath.Rel(filepath.Clean(root), name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: %w", aws.ToString(key), ErrKeyOutOfBucket)
+	}
+	return name, nil
+}
+
+// Get opens a file.
+func (s *LocalStorage) Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	name, err := s.path(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &types.NoSuchKey{Message: aws.String(err.Error())}
+		}
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &s3.GetObjectOutput{
+		Body:          f,
+		ContentLength: aws.Int64(st.Size()),
+		LastModified:  aws.Time(st.ModTime()),
+	}, nil
+}
+
+// Put writes a file atomically. Parent directories are created if not exist.
+// IfNoneMatch "*" is supported by hard links.
+func (s *LocalStorage) Put(ctx context.Context, in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	name, err := s.path(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if in.Body != nil {
+		if _, err := io.Copy(tmp, in.Body); err != nil {
+			tmp.Close()
+			return nil, err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return nil, err
+	}
+	if aws.ToString(in.IfNoneMatch) == "*" {
+		// link fails if the file already exists
+		if err := os.Link(tmp.Name(), name); err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				return nil, ErrPreconditionFailed
+			}
+			return nil, err
+		}
+		return &s3.PutObjectOutput{}, nil
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+// Head gets a file info.
+func (s *LocalStorage) Head(ctx context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	name, err := s.path(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &types.NotFound{Message: aws.String(err.Error())}
+		}
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, &types.NotFound{Message: aws.String("is a directory")}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(st.Size()),
+		LastModified:  aws.Time(st.ModTime()),
+	}, nil
+}
+
+// List lists files which have the prefix in the directory recursively, in key order.
+// All files are returned in one page.
+func (s *LocalStorage) List(ctx context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	root := aws.ToString(in.Bucket)
+	prefix := aws.ToString(in.Prefix)
+	absKey := root == "" // keys of file:// URLs are absolute paths
+	if absKey {
+		root = "/"
+	}
+	startAfter := aws.ToString(in.StartAfter)
+	out := &s3.ListObjectsV2Output{
+		Name:   in.Bucket,
+		Prefix: in.Prefix,
+	}
+	// walk from the deepest directory of the prefix
+	prefixDir := prefix[:strings.LastIndex(prefix, "/")+1]
+	if _, err := s.path(in.Bucket, &prefixDir); err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(root, filepath.FromSlash(prefixDir))
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if absKey {
+			key = "/" + key
+		}
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(info.Size()),
+			LastModified: aws.Time(info.ModTime()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out.Contents, func(i, j int) bool {
+		return *out.Contents[i].Key < *out.Contents[j].Key
+	})
+	out.KeyCount = aws.Int32(int32(len(out.Contents)))
+	out.IsTruncated = aws.Bool(false)
+	return out, nil
+}
+
+// Delete removes a file.
+func (s *LocalStorage) Delete(ctx context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	name, err := s.path(in.Bucket, in.Key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// Copy copies a file. CopySource is "bucket/key" as same as S3.
+func (s *LocalStorage) Copy(ctx context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	bucket, key, err := splitCopySource(aws.ToString(in.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	src, err := s.Get(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+	if err != nil {
+		return nil, err
+	}
+	defer src.Body.Close()
+	if _, err := s.Put(ctx, &s3.PutObjectInput{Bucket: in.Bucket, Key: in.Key, Body: src.Body}); err != nil {
+		return nil, err
+	}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+// GetTagging returns no tags.
+func (s *LocalStorage) GetTagging(ctx context.Context, in *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error) {
+	return &s3.GetObjectTaggingOutput{}, nil
+}
+
+// PutTagging is not supported.
+func (s *LocalStorage) PutTagging(ctx context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	return nil, errors.New("tagging is not supported by local storage")
+}
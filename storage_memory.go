@@ -0,0 +1,213 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MemoryStorage is a Storage on memory. It is useful for testing.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	objects map[string]*MemoryObject
+}
+
+// MemoryObject represents an object stored in MemoryStorage.
+type MemoryObject struct {
+	Body               []byte
+	Metadata           map[string]string
+	ContentType        string
+	ContentEncoding    string
+	CacheControl       string
+	ContentDisposition string
+	Tags               []types.Tag
+	ETag               string
+	LastModified       time.Time
+}
+
+// NewMemoryStorage creates a MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		objects: make(map[string]*MemoryObject),
+	}
+}
+
+func memoryObjectName(bucket, key *string) string {
+	return aws.ToString(bucket) + "/" + aws.ToString(key)
+}
+
+// Object returns a stored object. It returns nil if not exists.
+func (s *MemoryStorage) Object(bucket, key string) *MemoryObject {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[memoryObjectName(&bucket, &key)]
+}
+
+// Get gets an object.
+func (s *MemoryStorage) Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[memoryObjectName(in.Bucket, in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key")}
+	}
+	return &s3.GetObjectOutput{
+		Body:               io.NopCloser(bytes.NewReader(obj.Body)),
+		ContentLength:      aws.Int64(int64(len(obj.Body))),
+		Metadata:           maps.Clone(obj.Metadata),
+		ContentType:        aws.String(obj.ContentType),
+		ContentEncoding:    aws.String(obj.ContentEncoding),
+		CacheControl:       aws.String(obj.CacheControl),
+		ContentDisposition: aws.String(obj.ContentDisposition),
+		ETag:               aws.String(obj.ETag),
+		LastModified:       aws.Time(obj.LastModified),
+	}, nil
+}
+
+// Put puts an object.
+func (s *MemoryStorage) Put(ctx context.Context, in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	var body []byte
+	if in.Body != nil {
+		var err error
+		if body, err = io.ReadAll(in.Body); err != nil {
+			return nil, err
+		}
+	}
+	obj := &MemoryObject{
+		Body:               body,
+		Metadata:           maps.Clone(in.Metadata),
+		ContentType:        aws.ToString(in.ContentType),
+		ContentEncoding:    aws.ToString(in.ContentEncoding),
+		CacheControl:       aws.ToString(in.CacheControl),
+		ContentDisposition: aws.ToString(in.ContentDisposition),
+		Tags:               parseTagging(aws.ToString(in.Tagging)),
+		ETag:               fmt.Sprintf(`"%x"`, md5.Sum(body)),
+		LastModified:       time.Now(),
+	}
+	name := memoryObjectName(in.Bucket, in.Key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.objects[name]; exists && aws.ToString(in.IfNoneMatch) == "*" {
+		return nil, ErrPreconditionFailed
+	}
+	s.objects[name] = obj
+	return &s3.PutObjectOutput{ETag: aws.String(obj.ETag)}, nil
+}
+
+// Head gets metadata of an object.
+func (s *MemoryStorage) Head(ctx context.Context, in *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[memoryObjectName(in.Bucket, in.Key)]
+	if !ok {
+		return nil, &types.NotFound{Message: aws.String("not found")}
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength:      aws.Int64(int64(len(obj.Body))),
+		Metadata:           maps.Clone(obj.Metadata),
+		ContentType:        aws.String(obj.ContentType),
+		ContentEncoding:    aws.String(obj.ContentEncoding),
+		CacheControl:       aws.String(obj.CacheControl),
+		ContentDisposition: aws.String(obj.ContentDisposition),
+		ETag:               aws.String(obj.ETag),
+		LastModified:       aws.Time(obj.LastModified),
+	}, nil
+}
+
+// List lists objects in key order. All objects are returned in one page.
+func (s *MemoryStorage) List(ctx context.Context, in *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := memoryObjectName(in.Bucket, in.Prefix)
+	startAfter := memoryObjectName(in.Bucket, in.StartAfter)
+	out := &s3.ListObjectsV2Output{
+		Name:   in.Bucket,
+		Prefix: in.Prefix,
+	}
+	for name, obj := range s.objects {
+		if !strings.HasPrefix(name, prefix) || (in.StartAfter != nil && name <= startAfter) {
+			continue
+		}
+		out.Contents = append(out.Contents, types.Object{
+			Key:          aws.String(strings.TrimPrefix(name, aws.ToString(in.Bucket)+"/")),
+			Size:         aws.Int64(int64(len(obj.Body))),
+			ETag:         aws.String(obj.ETag),
+			LastModified: aws.Time(obj.LastModified),
+		})
+	}
+	sort.Slice(out.Contents, func(i, j int) bool {
+		return *out.Contents[i].Key < *out.Contents[j].Key
+	})
+	out.KeyCount = aws.Int32(int32(len(out.Contents)))
+	out.IsTruncated = aws.Bool(false)
+	return out, nil
+}
+
+// Delete deletes an object.
+func (s *MemoryStorage) Delete(ctx context.Context, in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, memoryObjectName(in.Bucket, in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// Copy copies an object with metadata and tags.
+func (s *MemoryStorage) Copy(ctx context.Context, in *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	bucket, key, err := splitCopySource(aws.ToString(in.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.objects[memoryObjectName(&bucket, &key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key")}
+	}
+	obj := *src
+	// the copy must not share the body, metadata and tags with the source
+	obj.Body = bytes.Clone(src.Body)
+	obj.Metadata = maps.Clone(src.Metadata)
+	obj.Tags = slices.Clone(src.Tags)
+	obj.LastModified = time.Now()
+	s.objects[memoryObjectName(in.Bucket, in.Key)] = &obj
+	return &s3.CopyObjectOutput{}, nil
+}
+
+// GetTagging gets tags of an object.
+func (s *MemoryStorage) GetTagging(ctx context.Context, in *s3.GetObjectTaggingInput) (*s3.GetObjectTaggingOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[memoryObjectName(in.Bucket, in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key")}
+	}
+	return &s3.GetObjectTaggingOutput{TagSet: obj.Tags}, nil
+}
+
+// PutTagging replaces tags of an object.
+func (s *MemoryStorage) PutTagging(ctx context.Context, in *s3.PutObjectTaggingInput) (*s3.PutObjectTaggingOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[memoryObjectName(in.Bucket, in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("no such key")}
+	}
+	if in.Tagging != nil {
+		obj.Tags = in.Tagging.TagSet
+	} else {
+		obj.Tags = nil
+	}
+	return &s3.PutObjectTaggingOutput{}, nil
+}
@@ -0,0 +1,327 @@
+package router_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/go-cmp/cmp"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+func testRouterOption(bucket string) *router.Option {
+	return &router.Option{
+		Bucket:           bucket,
+		KeyPrefix:        "foo/{{ replace .tag }}/{{ .datetime.Format `2006-01-02` }}/",
+		Replacer:         `{"app.*":"app"}`,
+		TimeParse:        true,
+		TimeKey:          "datetime",
+		TimeFormat:       "2006-01-02T15:04:05Z07:00",
+		PutS3:            true,
+		KeepOriginalName: true,
+	}
+}
+
+func TestRunMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	src, err := os.Open("testdata/json/example_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("example-bucket"),
+		Key:    aws.String("path/to/example-object"),
+		Body:   src,
+	})
+
+	r, err := router.New(testRouterOption("dummy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	if err := r.Run(ctx, "s3://example-bucket/path/to/example-object"); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := storage.List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("dummy")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]string, len(list.Contents))
+	for _, obj := range list.Contents {
+		o := storage.Object("dummy", *obj.Key)
+		if o.Metadata[router.MetaHeaderName] != "s3://example-bucket/path/to/example-object" {
+			t.Errorf("unexpected metadata of %s: %v", *obj.Key, o.Metadata)
+		}
+		res["s3://dummy/"+*obj.Key] = string(o.Body)
+	}
+	expected := readRouterGolden(t, "testdata/json/example_log.golden")
+	if d := cmp.Diff(expected, res); d != "" {
+		t.Error("unexpected routed data:", d)
+	}
+
+	// loop guard
+	if err := r.Run(ctx, "s3://dummy/"+*list.Contents[0].Key); err == nil {
+		t.Error("routed object must not be routed again")
+	}
+}
+
+func TestRunLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src, err := filepath.Abs("testdata/json/example_log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := router.New(testRouterOption("file://" + dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(ctx, "file://"+src); err != nil {
+		t.Fatal(err)
+	}
+	list, err := router.NewLocalStorage().List(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(dir)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]string, len(list.Contents))
+	for _, obj := range list.Contents {
+		b, err := os.ReadFile(filepath.Join(dir, *obj.Key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res["s3://dummy/"+*obj.Key] = string(b)
+	}
+	// routed object names are the same as the source
+	expected := make(map[string]string)
+	for name, content := range readRouterGolden(t, "testdata/json/example_log.golden") {
+		expected[strings.TrimSuffix(name, "example-object")+"example_log"] = content
+	}
+	if d := cmp.Diff(expected, res); d != "" {
+		t.Error("unexpected routed data:", d)
+	}
+}
+
+func TestLocalStorageOutOfBucket(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	dir := filepath.Join(root, "dest")
+	storage := router.NewLocalStorage()
+	for _, key := range []string{"../x.log", "a/../../x.log", "../dest2/x.log"} {
+		_, err := storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(dir),
+			Key:    aws.String(key),
+			Body:   strings.NewReader("x"),
+		})
+		if !errors.Is(err, router.ErrKeyOutOfBucket) {
+			t.Errorf("%s: unexpected error %v", key, err)
+		}
+	}
+	if _, err := storage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(dir),
+		Key:    aws.String("a/../x.log"),
+		Body:   strings.NewReader("x"),
+	}); err != nil {
+		t.Error(err)
+	}
+
+	// a key prefix rendered by a record must not escape the destination
+	src := filepath.Join(root, "src.log")
+	if err := os.WriteFile(src, []byte(`{"tag":"../../etc"}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opt := testRouterOption("file://" + dir)
+	opt.KeyPrefix = "{{ .tag }}"
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(ctx, "file://"+src); !errors.Is(err, router.ErrKeyOutOfBucket) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "..", "etc", "src.log")); err == nil {
+		t.Error("a file is written out of the bucket")
+	}
+}
+
+func TestMemoryStorageMetadata(t *testing.T) {
+	ctx := context.Background()
+	storage := router.NewMemoryStorage()
+	meta := map[string]string{"a": "1"}
+	storage.Put(ctx, &s3.PutObjectInput{
+		Bucket:   aws.String("b"),
+		Key:      aws.String("k"),
+		Metadata: meta,
+	})
+	meta["a"] = "2"
+	if v := storage.Object("b", "k").Metadata["a"]; v != "1" {
+		t.Errorf("stored metadata must not be changed: %s", v)
+	}
+
+	if _, err := storage.Copy(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String("b"),
+		Key:        aws.String("copied"),
+		CopySource: aws.String("b/k"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Object("b", "copied").Metadata["a"] = "3"
+	if v := storage.Object("b", "k").Metadata["a"]; v != "1" {
+		t.Errorf("metadata of the copy source must not be changed: %s", v)
+	}
+}
+
+// flakyStorage fails puts to the failing keys for the specified times.
+type flakyStorage struct {
+	*router.MemoryStorage
+	mu      sync.Mutex
+	failing map[string]int
+	puts    int
+}
+
+func (s *flakyStorage) Put(ctx context.Context, in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	s.mu.Lock()
+	s.puts++
+	if n := s.failing[*in.Key]; n > 0 {
+		s.failing[*in.Key] = n - 1
+		s.mu.Unlock()
+		return nil, errors.New("flaky")
+	}
+	s.mu.Unlock()
+	return s.MemoryStorage.Put(ctx, in)
+}
+
+func TestRunRetry(t *testing.T) {
+	ctx := context.Background()
+	storage := &flakyStorage{MemoryStorage: router.NewMemoryStorage()}
+	storage.MemoryStorage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("src"),
+		Key:    aws.String("x.log"),
+		Body:   strings.NewReader(`{"tag":"a"}` + "\n" + `{"tag":"b"}` + "\n"),
+	})
+	opt := testRouterOption("dest")
+	opt.KeyPrefix = "{{ .tag }}"
+	opt.PutRetries = 1
+	opt.PutRetryWait = "1ms"
+	opt.SkipUnchanged = true
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+
+	// a/x.log fails beyond retries, b/x.log succeeds after a retry
+	storage.failing = map[string]int{"a/x.log": 2, "b/x.log": 1}
+	result, err := r.RunWithResult(ctx, "s3://src/x.log")
+	if err == nil {
+		t.Error("must be failed")
+	}
+	status := map[string]string{}
+	for _, o := range result.Outputs {
+		status[o.Destination] = o.Status
+	}
+	expected := map[string]string{"s3://dest/a/x.log": router.OutputFailed, "s3://dest/b/x.log": router.OutputPut}
+	if d := cmp.Diff(expected, status); d != "" {
+		t.Error("unexpected outputs:", d)
+	}
+
+	// rerun skips b/x.log which is already written
+	result, err = r.RunWithResult(ctx, "s3://src/x.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	status = map[string]string{}
+	for _, o := range result.Outputs {
+		status[o.Destination] = o.Status
+	}
+	expected = map[string]string{"s3://dest/a/x.log": router.OutputPut, "s3://dest/b/x.log": router.OutputSkipped}
+	if d := cmp.Diff(expected, status); d != "" {
+		t.Error("unexpected outputs:", d)
+	}
+}
+
+// cancelStorage cancels the context when the source object is read.
+type cancelStorage struct {
+	*router.MemoryStorage
+	cancel context.CancelFunc
+}
+
+func (s *cancelStorage) Get(ctx context.Context, in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	s.cancel()
+	return s.MemoryStorage.Get(ctx, in)
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	storage := &cancelStorage{MemoryStorage: router.NewMemoryStorage(), cancel: cancel}
+	storage.MemoryStorage.Put(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("src"),
+		Key:    aws.String("x.log"),
+		Body:   strings.NewReader(`{"tag":"a"}` + "\n"),
+	})
+	opt := testRouterOption("dest")
+	opt.KeyPrefix = "{{ .tag }}"
+	r, err := router.New(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetSourceStorage("s3", storage)
+	r.SetDestinationStorage(storage)
+	result, err := r.RunWithResult(ctx, "s3://src/x.log")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("unexpected error %v", err)
+	}
+	if len(result.Outputs) != 1 || result.Outputs[0].Status != router.OutputFailed {
+		t.Errorf("unexpected outputs %#v", result.Outputs)
+	}
+	if storage.Object("dest", "a/x.log") != nil {
+		t.Error("must not be put with the canceled context")
+	}
+}
+
+func TestRunWritePolicy(t *testing.T) {
+	ctx := context.Background()
+	for _, policy := range []string{router.WritePolicySkipIfExists, router.WritePolicyFailIfExists} {
+		storage := router.NewMemoryStorage()
+		storage.Put(ctx, &s3.PutObjectInput{
+			Bucket: aws.String("src"),
+			Key:    aws.String("x.log"),
+			Body:   strings.NewReader(`{"tag":"a"}` + "\n"),
+		})
+		opt := testRouterOption("dest")
+		opt.KeyPrefix = "{{ .tag }}"
+		opt.WritePolicy = policy
+		r, err := router.New(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetSourceStorage("s3", storage)
+		r.SetDestinationStorage(storage)
+		if err := r.Run(ctx, "s3://src/x.log"); err != nil {
+			t.Fatal(policy, err)
+		}
+		result, err := r.RunWithResult(ctx, "s3://src/x.log")
+		switch policy {
+		case router.WritePolicySkipIfExists:
+			if err != nil || result.Outputs[0].Status != router.OutputExists {
+				t.Errorf("%s: unexpected result %#v %s", policy, result.Outputs[0], err)
+			}
+		case router.WritePolicyFailIfExists:
+			if !errors.Is(err, router.ErrDestinationExists) || result.Outputs[0].Status != router.OutputFailed {
+				t.Errorf("%s: unexpected result %#v %s", policy, result.Outputs[0], err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template/parse"
+	"time"
+)
+
+// timeMethods are methods of time.Time which are often called in templates.
+var timeMethods = map[string]bool{
+	"Format": true, "Year": true, "Month": true, "Day": true, "Hour": true,
+	"Minute": true, "Second": true, "YearDay": true, "Weekday": true, "Unix": true,
+	"UTC": true, "Local": true, "In": true, "Add": true, "Truncate": true,
+}
+
+// cloudfrontTimeSample is a sample of datetime generated by the cloudfront parser.
+const cloudfrontTimeSample = "2019-12-04T21:02:31Z"
+
+// Validate checks the configuration more strictly than New.
+// It reports problems which only surface at runtime as dropped records,
+// e.g. a time format without layout elements, or a template which calls
+// methods of time.Time on a field not parsed as time.
+func (r *Router) Validate() error {
+	var errs []error
+	opt := r.option
+	if opt.TimeParse {
+		sample := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC).Format(opt.TimeFormat)
+		if sample == opt.TimeFormat {
+			errs = append(errs, fmt.Errorf("time-format %q has no layout elements", opt.TimeFormat))
+		} else if _, err := time.Parse(opt.TimeFormat, sample); err != nil {
+			errs = append(errs, fmt.Errorf("time-format %q is invalid: %w", opt.TimeFormat, err))
+		}
+		if opt.Parser == "cloudfront" && opt.TimeKey == "datetime" {
+			if _, err := time.Parse(opt.TimeFormat, cloudfrontTimeSample); err != nil {
+				errs = append(errs, fmt.Errorf("time-format %q can not parse datetime of cloudfront parser: %w", opt.TimeFormat, err))
+			}
+		}
+	}
+
+	templates := map[string]string{
+		"key-prefix":     opt.KeyPrefix,
+		"storage-class":  opt.StorageClass,
+		"sse":            opt.SSE,
+		"sse-kms-key-id": opt.SSEKMSKeyID,
+		"acl":            opt.ACL,
+	}
+	for key, text := range opt.tags {
+		templates["tags."+key] = text
+	}
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if templates[name] == "" {
+			continue
+		}
+		trees, err := parse.Parse(name, templates[name], "", "", newTemplateFuncs(opt.replacer.Replace), builtinFuncs)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, field := range timeFields(trees[name].Root) {
+			if !opt.TimeParse {
+				errs = append(errs, fmt.Errorf("%s calls .%s but time-parse is disabled", name, strings.Join(field, ".")))
+			} else if field[0] != opt.TimeKey {
+				errs = append(errs, fmt.Errorf("%s calls .%s but time-key is %s", name, strings.Join(field, "."), opt.TimeKey))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// builtinFuncs are names of builtin functions of text/template for parsing.
+var builtinFuncs = map[string]any{
+	"and": nil, "call": nil, "html": nil, "index": nil, "slice": nil, "js": nil, "len": nil,
+	"not": nil, "or": nil, "print": nil, "printf": nil, "println": nil, "urlquery": nil,
+	"eq": nil, "ge": nil, "gt": nil, "le": nil, "lt": nil, "ne": nil,
+}
+
+// timeFields returns fields in the template which call methods of time.Time. e.g. [time Format]
+func timeFields(node parse.Node) [][]string {
+	var fields [][]string
+	var walk func(parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				for _, arg := range cmd.Args {
+					walk(arg)
+				}
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.FieldNode:
+			if len(n.Ident) >= 2 && timeMethods[n.Ident[1]] {
+				fields = append(fields, n.Ident[:2])
+			}
+		}
+	}
+	walk(node)
+	return fields
+}
@@ -0,0 +1,46 @@
+package router_test
+
+import (
+	"testing"
+
+	router "github.com/kayac/s3-object-router"
+)
+
+var validateTests = []struct {
+	keyPrefix  string
+	timeParse  bool
+	timeFormat string
+	parser     string
+	valid      bool
+}{
+	{"foo/{{ .datetime.Format `2006` }}", true, "2006-01-02T15:04:05Z07:00", "json", true},
+	{"foo/{{ .datetime.Format `2006` }}", false, "2006-01-02T15:04:05Z07:00", "json", false},
+	{"foo/{{ .time.Year }}", true, "2006-01-02T15:04:05Z07:00", "json", false},
+	{"foo/{{ if .tag }}{{ .datetime.Format `2006` }}{{ end }}", false, "", "json", false},
+	{"foo/{{ .tag }}", true, "YYYY-MM-DD", "json", false},
+	{"foo/{{ .tag }}", true, "2006/01/02 15:04:05", "cloudfront", false},
+	{"foo/{{ .tag }}", true, "2006-01-02T15:04:05Z", "cloudfront", true},
+}
+
+func TestValidate(t *testing.T) {
+	for _, ts := range validateTests {
+		opt := &router.Option{
+			Bucket:     "dest",
+			KeyPrefix:  ts.keyPrefix,
+			TimeParse:  ts.timeParse,
+			TimeKey:    "datetime",
+			TimeFormat: ts.timeFormat,
+			Parser:     ts.parser,
+		}
+		r, err := router.New(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = r.Validate()
+		if ts.valid && err != nil {
+			t.Errorf("%#v must be valid: %s", ts, err)
+		} else if !ts.valid && err == nil {
+			t.Errorf("%#v must be invalid", ts)
+		}
+	}
+}
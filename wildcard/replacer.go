@@ -17,10 +17,18 @@ func NewReplacer(oldnew ...string) *Replacer {
 
 // Replace replaces a string
 func (r *Replacer) Replace(s string) string {
+	if _, replacement, ok := r.Rule(s); ok {
+		return replacement
+	}
+	return s
+}
+
+// Rule returns the first pattern which matches s and its replacement.
+func (r *Replacer) Rule(s string) (pattern, replacement string, ok bool) {
 	for i := 0; i < len(r.oldnew)-1; i += 2 {
 		if Match(r.oldnew[i], s) {
-			return r.oldnew[i+1]
+			return r.oldnew[i], r.oldnew[i+1], true
 		}
 	}
-	return s
+	return "", "", false
 }
@@ -27,3 +27,13 @@ func TestReplacer(t *testing.T) {
 		}
 	}
 }
+
+func TestReplacerRule(t *testing.T) {
+	rp := NewReplacer(testReplacer...)
+	if pattern, replacement, ok := rp.Rule("app.warn.xxx"); !ok || pattern != "app.warn.**" || replacement != "app.warn" {
+		t.Errorf("unexpected rule %s => %s", pattern, replacement)
+	}
+	if _, _, ok := rp.Rule("prefix.app.test.xxx"); ok {
+		t.Error("rule must not match")
+	}
+}